@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Page size used when only ?offset= is given
+const defaultPageLimit = 100
+
+// listPage selects a window of a key-sorted listing.
+type listPage struct {
+	Offset int
+	Limit  int
+}
+
+// parsePage reads ?offset= and ?limit= and reports whether either was given.
+func parsePage(r *http.Request) (listPage, bool, error) {
+	query := r.URL.Query()
+	page := listPage{Limit: defaultPageLimit}
+	paged := false
+	for name, dst := range map[string]*int{"offset": &page.Offset, "limit": &page.Limit} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return page, false, errors.New(name + " must be a non-negative integer")
+		}
+		*dst = n
+		paged = true
+	}
+	return page, paged, nil
+}
+
+// apply sorts keys and returns the page's window of them.
+func (p listPage) apply(keys []string) []string {
+	slices.Sort(keys)
+	start := min(p.Offset, len(keys))
+	return keys[start:min(start+p.Limit, len(keys))]
+}
+
+// gatewaySeed pins a payment to a gateway. The optional fields override the
+// derived ES document fields for that payment.
+type gatewaySeed struct {
+	PaymentId string     `json:"paymentId"`
+	Gateway   string     `json:"gateway"`
+	Status    string     `json:"status,omitempty"`
+	Amount    *int64     `json:"amount,omitempty"`
+	Currency  string     `json:"currency,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+func (s gatewaySeed) hasDetails() bool {
+	return s.Amount != nil || s.Currency != "" || s.CreatedAt != nil
+}
+
+// invalid explains what is wrong with the seed given the registered
+// gateways, or returns "" when it is valid.
+func (s gatewaySeed) invalid(known []string) string {
+	switch {
+	case s.PaymentId == "":
+		return "paymentId is required"
+	case !slices.Contains(known, s.Gateway):
+		return "Unknown gateway '" + s.Gateway + "' for payment '" + s.PaymentId + "'"
+	case s.Status != "" && !slices.Contains(paymentStatuses, s.Status):
+		return "Unknown status '" + s.Status + "' for payment '" + s.PaymentId + "'"
+	case s.Amount != nil && *s.Amount < 0:
+		return "amount must not be negative for payment '" + s.PaymentId + "'"
+	case s.Currency != "" && len(s.Currency) != 3:
+		return "currency must be a 3-letter code for payment '" + s.PaymentId + "'"
+	}
+	return ""
+}
+
+// apply pins the seed's payment to its gateway in cache, cached at, and
+// sets its status and details when given. Caller must hold cacheMutex and
+// gatewayCacheMutex.
+func (s gatewaySeed) apply(cache *lruCache[gatewayEntry], at time.Time) {
+	cache.Put(s.PaymentId, gatewayEntry{Gateway: s.Gateway, CachedAt: at})
+	if s.Status != "" {
+		paymentStates[s.PaymentId] = &paymentState{Status: s.Status, UpdatedAt: at}
+	}
+	if s.hasDetails() {
+		details := lookupPaymentDetails(s.PaymentId)
+		if s.Amount != nil {
+			details.Amount = *s.Amount
+		}
+		if s.Currency != "" {
+			details.Currency = s.Currency
+		}
+		if s.CreatedAt != nil {
+			details.CreatedAt = *s.CreatedAt
+		}
+		paymentDetailOverrides[s.PaymentId] = details
+	}
+}
+
+// handleSeedGatewayCache pins payments to gateways, bypassing determineGateway.
+// Accepts a single {"paymentId":"pay_123","gateway":"adyen"} or an array of
+// them; each may also set status, amount (minor units), currency and createdAt.
+// ?tenant= seeds that tenant's gateway cache; status and details are shared.
+func handleSeedGatewayCache(w http.ResponseWriter, r *http.Request) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	var seeds []gatewaySeed
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &seeds); err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+			return
+		}
+	} else {
+		var seed gatewaySeed
+		if err := json.Unmarshal(trimmed, &seed); err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+			return
+		}
+		seeds = []gatewaySeed{seed}
+	}
+
+	known := currentGateways()
+	for _, seed := range seeds {
+		if reason := seed.invalid(known); reason != "" {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", reason)
+			return
+		}
+	}
+
+	caches, ok := adminTenant(w, r, true)
+	if !ok {
+		return
+	}
+
+	now := clockNow()
+	cacheMutex.Lock()
+	gatewayCacheMutex.Lock()
+	for _, seed := range seeds {
+		seed.apply(caches.gateway, now)
+	}
+	gatewayCacheMutex.Unlock()
+	cacheMutex.Unlock()
+
+	logger.Info("Gateway cache seeded", "endpoint", "admin", "count", len(seeds))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "seeded",
+		"count":  len(seeds),
+		"seeded": seeds,
+	})
+}
+
+// cacheEntries is the part of lruCache the per-entry admin endpoints use.
+type cacheEntries interface {
+	Contains(key string) bool
+	Remove(key string) bool
+}
+
+// byName returns the tenant's gateway, idb or pgi cache.
+func (c *tenantCaches) byName(cacheName string) cacheEntries {
+	switch cacheName {
+	case "gateway":
+		return c.gateway
+	case "idb":
+		return c.idb
+	default:
+		return c.pgi
+	}
+}
+
+// handleDeleteCacheEntry returns a handler that removes the {key} path value
+// from one of the success caches (guarded by mu) of the ?tenant= tenant,
+// responding 404 if it was not cached.
+func handleDeleteCacheEntry(cacheName string, mu *sync.RWMutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		caches, ok := adminTenant(w, r, false)
+		if !ok {
+			return
+		}
+
+		mu.Lock()
+		removed := caches.byName(cacheName).Remove(key)
+		mu.Unlock()
+
+		if !removed {
+			writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "No "+cacheName+" cache entry for '"+key+"'")
+			return
+		}
+
+		logger.Info("Cache entry deleted", "endpoint", "admin", "cache", cacheName, "key", key)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status": "deleted",
+			"cache":  cacheName,
+			"key":    key,
+		})
+	}
+}
+
+// handleGetGatewayCacheEntry returns the cached gateway for one payment of the
+// ?tenant= tenant, or 404 if it is not cached (or has expired).
+func handleGetGatewayCacheEntry(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("key")
+	caches, ok := adminTenant(w, r, false)
+	if !ok {
+		return
+	}
+
+	ttl := currentGatewayCacheTTL()
+	gatewayCacheMutex.RLock()
+	entry, exists := caches.gateway.Peek(paymentId)
+	gatewayCacheMutex.RUnlock()
+	expired := exists && entry.expired(clockNow(), ttl)
+
+	if !exists || expired {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "No gateway cache entry for '"+paymentId+"'")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"paymentId": paymentId,
+		"gateway":   entry.Gateway,
+		"cachedAt":  entry.CachedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+// handleGetSuccessCacheEntry returns a handler reporting whether the {key}
+// path value is in one of the success sets (guarded by mu) of the ?tenant=
+// tenant, responding 404 if it is not.
+func handleGetSuccessCacheEntry(cacheName string, mu *sync.RWMutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.PathValue("key")
+		caches, ok := adminTenant(w, r, false)
+		if !ok {
+			return
+		}
+
+		mu.RLock()
+		cached := caches.byName(cacheName).Contains(key)
+		mu.RUnlock()
+
+		if !cached {
+			writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "No "+cacheName+" cache entry for '"+key+"'")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"cache":  cacheName,
+			"key":    key,
+			"cached": true,
+		})
+	}
+}
+
+// handleExportCache downloads the caches. The default JSON is the same shape
+// as CACHE_FILE and can be fed back to /admin/cache/import; ?format=csv gives
+// just the gateway cache as paymentId,gateway rows for spreadsheets. Without
+// ?tenant= the JSON covers every tenant; with it, only that tenant's caches.
+func handleExportCache(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "format must be json or csv")
+		return
+	}
+
+	var snapshot cacheSnapshot
+	if r.URL.Query().Has("tenant") {
+		caches, ok := adminTenant(w, r, false)
+		if !ok {
+			return
+		}
+		rlockCaches()
+		snapshot = snapshotTenant(caches)
+		runlockCaches()
+	} else {
+		snapshot = snapshotCaches()
+	}
+	if format != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot)
+		return
+	}
+
+	view := snapshot.GatewayCache
+	paymentIds := slices.Sorted(maps.Keys(view))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="gateway-cache.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"paymentId", "gateway"})
+	for _, paymentId := range paymentIds {
+		out.Write([]string{paymentId, view[paymentId]})
+	}
+	out.Flush()
+}
+
+// handleImportCache loads entries in the export JSON shape. ?mode=merge (the
+// default) adds to the current caches, ?mode=replace clears them first.
+// Entries with an empty key or an unregistered gateway are skipped and
+// counted rather than failing the whole import. Without ?tenant= the
+// top-level entries go to the default tenant and nested tenants are created
+// as needed (replace drops the other named tenants); with it, the top-level
+// entries go to that tenant and nested ones are ignored.
+func handleImportCache(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "merge"
+	}
+	if mode != "merge" && mode != "replace" {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "mode must be merge or replace")
+		return
+	}
+
+	var snapshot cacheSnapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	tenant := r.URL.Query().Get("tenant")
+	caches, ok := adminTenant(w, r, true)
+	if !ok {
+		return
+	}
+
+	known := currentGateways()
+	now := clockNow()
+
+	lockCaches()
+	if mode == "replace" {
+		caches.gateway.Clear()
+		caches.idb.Clear()
+		caches.pgi.Clear()
+	}
+	imported, skipped := importTenant(caches, snapshot, known, now)
+	if tenant == "" {
+		if mode == "replace" {
+			tenantMutex.Lock()
+			tenants = make(map[string]*tenantCaches)
+			tenantMutex.Unlock()
+		}
+		for name, dump := range snapshot.Tenants {
+			if !validNamedTenant(name) {
+				skipped += dump.entries()
+				continue
+			}
+			named, err := createTenant(name)
+			if err != nil {
+				skipped += dump.entries()
+				continue
+			}
+			added, rejected := importTenant(named, dump, known, now)
+			imported += added
+			skipped += rejected
+		}
+	}
+	unlockCaches()
+
+	logger.Info("Cache imported", "endpoint", "admin", "mode", mode, "tenant", tenant, "imported", imported, "skipped", skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"mode": mode, "imported": imported, "skipped": skipped})
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+// uiFiles holds the admin dashboard: one self-contained page (inline CSS and
+// JS, no external assets) that polls the admin JSON endpoints.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// handleAdminUI serves the dashboard. The page carries no data of its own, so
+// it's served without the admin key; the admin endpoints it polls still ask
+// for one, which the page prompts for on the first 401.
+func handleAdminUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFileFS(w, r, uiFiles, "ui/admin.html")
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Audit entries kept in memory; the oldest are overwritten
+	auditLogSize = 1000
+
+	// Request bodies beyond this are kept cut short (bodyTruncated)
+	maxAuditBody = 4 << 10
+)
+
+// auditEntry is one admin mutation: who sent what, and what came of it.
+type auditEntry struct {
+	Time          time.Time `json:"time"`
+	RequestId     string    `json:"requestId"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"` // with the query string
+	Body          string    `json:"body,omitempty"`
+	BodyTruncated bool      `json:"bodyTruncated,omitempty"`
+	Status        int       `json:"status"`
+	RemoteIp      string    `json:"remoteIp"`
+	ClientId      string    `json:"clientId,omitempty"` // X-Client-Id
+	KeyId         string    `json:"keyId,omitempty"`    // fingerprint of the admin key presented
+}
+
+var (
+	// Ring buffer of the last auditLogSize entries; auditNext is where the
+	// next one goes
+	auditMutex sync.Mutex
+	auditLog   = make([]auditEntry, 0, auditLogSize)
+	auditNext  int
+)
+
+// auditedRequest reports whether r changes simulator state. Snapshots are
+// POSTed but only read.
+func auditedRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return r.URL.Path != "/admin/snapshot"
+}
+
+// withAudit records every admin mutation, with its request body and
+// response status, in the audit log and as an "Admin change" log line. The
+// handler sees the body unchanged.
+func withAudit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !auditedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		var body []byte
+		truncated := false
+		if r.Body != nil && r.Body != http.NoBody {
+			prefix, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+			truncated = len(prefix) > maxAuditBody
+			body = prefix[:min(len(prefix), maxAuditBody)]
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		entry := auditEntry{
+			Time:          start.UTC(),
+			RequestId:     requestId(r),
+			Method:        r.Method,
+			Path:          r.URL.RequestURI(),
+			Body:          strings.ToValidUTF8(string(body), "�"),
+			BodyTruncated: truncated,
+			Status:        rec.status,
+			RemoteIp:      remoteIp(r),
+			ClientId:      r.Header.Get("X-Client-Id"),
+			KeyId:         adminKeyId(r),
+		}
+		appendAudit(entry)
+		logger.Info("Admin change", "endpoint", "admin", "requestId", entry.RequestId, "method", entry.Method,
+			"path", entry.Path, "status", entry.Status, "remoteIp", entry.RemoteIp, "clientId", entry.ClientId, "keyId", entry.KeyId)
+	})
+}
+
+func appendAudit(entry auditEntry) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if len(auditLog) < auditLogSize {
+		auditLog = append(auditLog, entry)
+	} else {
+		auditLog[auditNext] = entry
+	}
+	auditNext = (auditNext + 1) % auditLogSize
+}
+
+// recentAudit returns up to limit entries, newest first.
+func recentAudit(limit int) []auditEntry {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	entries := make([]auditEntry, 0, min(limit, len(auditLog)))
+	for i := 1; i <= len(auditLog) && len(entries) < limit; i++ {
+		entries = append(entries, auditLog[(auditNext-i+auditLogSize)%auditLogSize])
+	}
+	return entries
+}
+
+func remoteIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// adminKeyId identifies the admin key a request presented without revealing
+// it: the first 8 hex digits of its SHA-256. Empty when none was presented.
+func adminKeyId(r *http.Request) string {
+	provided := r.Header.Get("X-Admin-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		provided = bearer
+	}
+	if provided == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(provided))
+	return hex.EncodeToString(sum[:4])
+}
+
+// handleGetAudit returns the most recent admin mutations, newest first; at
+// most ?limit= of them (default 100).
+func handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	limit := defaultPageLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "limit must be a non-negative integer")
+			return
+		}
+		limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recentAudit(limit))
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Shared secret for /admin/* (from ADMIN_KEY); empty leaves admin open
+var adminKey string
+
+// requireAdminKey rejects requests that don't present adminKey either as
+// "Authorization: Bearer <key>" or "X-Admin-Key: <key>".
+func requireAdminKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		provided := r.Header.Get("X-Admin-Key")
+		if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			provided = bearer
+		}
+
+		if provided == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(adminKey)) != 1 {
+			logger.Warn("Rejected unauthenticated admin request", "endpoint", "admin", "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			writeProblem(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized", "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
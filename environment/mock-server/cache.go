@@ -0,0 +1,135 @@
+package main
+
+import "container/list"
+
+// lruCache is a string-keyed cache with optional LRU eviction. It is not safe
+// for concurrent use; callers hold the cache's lock (see cache_locks.go). Get
+// promotes the entry, so reads that go through Get need the write lock.
+type lruCache[V any] struct {
+	maxEntries int // 0 means unlimited
+	order      *list.List
+	items      map[string]*list.Element
+	evictions  int64
+}
+
+type lruItem[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](maxEntries int) *lruCache[V] {
+	return &lruCache[V]{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value for key and marks it as most recently used.
+func (c *lruCache[V]) Get(key string) (V, bool) {
+	if el, ok := c.items[key]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*lruItem[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Peek returns the value for key without affecting recency.
+func (c *lruCache[V]) Peek(key string) (V, bool) {
+	if el, ok := c.items[key]; ok {
+		return el.Value.(*lruItem[V]).value, true
+	}
+	var zero V
+	return zero, false
+}
+
+func (c *lruCache[V]) Contains(key string) bool {
+	_, ok := c.items[key]
+	return ok
+}
+
+// Put inserts or replaces key, evicting the least recently used entry if the
+// cache is over capacity.
+func (c *lruCache[V]) Put(key string, value V) {
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruItem[V]).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruItem[V]{key: key, value: value})
+	c.evictOverflow()
+}
+
+// Remove deletes key and reports whether it was present.
+func (c *lruCache[V]) Remove(key string) bool {
+	el, ok := c.items[key]
+	if !ok {
+		return false
+	}
+	c.order.Remove(el)
+	delete(c.items, key)
+	return true
+}
+
+func (c *lruCache[V]) Len() int {
+	return len(c.items)
+}
+
+// Keys returns the keys from most to least recently used.
+func (c *lruCache[V]) Keys() []string {
+	result := make([]string, 0, len(c.items))
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		result = append(result, el.Value.(*lruItem[V]).key)
+	}
+	return result
+}
+
+// Range calls fn for each entry from most to least recently used.
+func (c *lruCache[V]) Range(fn func(key string, value V)) {
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		item := el.Value.(*lruItem[V])
+		fn(item.key, item.value)
+	}
+}
+
+// Clear drops all entries; the size limit and eviction count are kept.
+func (c *lruCache[V]) Clear() {
+	c.order.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// SetMaxEntries changes the size limit, evicting immediately if the cache is
+// now over capacity.
+func (c *lruCache[V]) SetMaxEntries(maxEntries int) {
+	c.maxEntries = maxEntries
+	c.evictOverflow()
+}
+
+func (c *lruCache[V]) MaxEntries() int {
+	return c.maxEntries
+}
+
+func (c *lruCache[V]) Evictions() int64 {
+	return c.evictions
+}
+
+func (c *lruCache[V]) evictOverflow() {
+	for c.maxEntries > 0 && len(c.items) > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem[V]).key)
+		c.evictions++
+	}
+}
+
+// cacheStats describes one cache's size limit for the admin endpoints.
+type cacheStats struct {
+	Size       int   `json:"size"`
+	MaxEntries int   `json:"maxEntries"`
+	Evictions  int64 `json:"evictions"`
+}
+
+func (c *lruCache[V]) stats() cacheStats {
+	return cacheStats{Size: c.Len(), MaxEntries: c.maxEntries, Evictions: c.evictions}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Clearing the caches needs ?confirm=true or this X-Confirm header value, so
+// a stray POST can't wipe a shared instance. ?dryRun=true needs neither.
+const (
+	confirmHeader    = "X-Confirm"
+	clearCacheToken  = "clear-cache"
+	confirmClearHint = "Add ?confirm=true or " + confirmHeader + ": " + clearCacheToken + " to clear the cache, or ?dryRun=true to see what would be cleared"
+)
+
+// What ?scope= clears: one cache kind (with its companions, e.g. the
+// idempotency keys and dedup with idb) or all of them, the default.
+const (
+	scopeGateway = "gateway"
+	scopeIdb     = "idb"
+	scopePgi     = "pgi"
+	scopeAll     = "all"
+)
+
+var clearScopes = []string{scopeGateway, scopeIdb, scopePgi, scopeAll}
+
+func clearConfirmed(r *http.Request) bool {
+	return strings.EqualFold(r.URL.Query().Get("confirm"), "true") || r.Header.Get(confirmHeader) == clearCacheToken
+}
+
+// handleAdminCacheClear empties every cache and drops all named tenants.
+// With ?tenant= only that tenant's caches are cleared: a named tenant is
+// dropped, "default" keeps the shared customer gateways and the other
+// tenants. ?scope=gateway, idb or
+// pgi clears only that kind of cache, e.g. to re-test the PGI retry path
+// without re-warming ES; tenants are then kept. Without confirmation it
+// answers 428; ?dryRun=true reports the entries per cache that would go
+// without clearing anything.
+func handleAdminCacheClear(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = scopeAll
+	}
+	if !slices.Contains(clearScopes, scope) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+			"scope must be one of "+strings.Join(clearScopes, ", "))
+		return
+	}
+	tenant := query.Get("tenant")
+	if tenant != "" {
+		if _, err := lookupTenant(tenant, false); err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+			return
+		}
+	}
+	dryRun := strings.EqualFold(query.Get("dryRun"), "true")
+	if !dryRun && !clearConfirmed(r) {
+		writeProblem(w, http.StatusPreconditionRequired, codeConfirmationRequired, "Confirmation Required", confirmClearHint)
+		return
+	}
+
+	lockCaches()
+	entries := clearCaches(tenant, scope, dryRun)
+	unlockCaches()
+
+	status := "cache cleared"
+	if dryRun {
+		status = "dry run"
+	} else {
+		logger.Info("Cache cleared", "endpoint", "admin", "tenant", tenant, "scope", scope, "entries", entries)
+	}
+
+	response := map[string]any{"status": status, "scope": scope, "dryRun": dryRun, "entries": entries}
+	if tenant != "" {
+		response["tenant"] = tenant
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// clearCaches empties the scope's caches of tenant, or of every tenant when
+// tenant is "", and returns how many entries each held. With dryRun it only
+// counts. Caller must hold the cache locks (lockCaches).
+func clearCaches(tenant, scope string, dryRun bool) map[string]int {
+	entries := make(map[string]int)
+	inScope := func(kind string) bool { return scope == scopeAll || scope == kind }
+	for _, kind := range []string{scopeGateway, scopeIdb, scopePgi} {
+		if inScope(kind) {
+			entries[kind] = 0
+		}
+	}
+	drop := func(name string, cache interface {
+		Len() int
+		Clear()
+	}) {
+		entries[name] += cache.Len()
+		if !dryRun {
+			cache.Clear()
+		}
+	}
+	dropTenant := func(caches *tenantCaches) {
+		if inScope(scopeGateway) {
+			drop(scopeGateway, caches.gateway)
+		}
+		if inScope(scopeIdb) {
+			drop(scopeIdb, caches.idb)
+			drop("idbIdempotencyKeys", caches.idempotencyKeys)
+			drop("idbNotifiedAt", caches.notifiedAt)
+		}
+		if inScope(scopePgi) {
+			drop(scopePgi, caches.pgi)
+		}
+	}
+
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+	switch tenant {
+	case "":
+		dropTenant(defaultCaches)
+		if inScope(scopeGateway) {
+			drop("customerGateway", customerGatewayCache)
+		}
+		for _, caches := range tenants {
+			dropTenant(caches)
+		}
+		if scope == scopeAll {
+			entries["tenants"] = len(tenants)
+			if !dryRun {
+				tenants = make(map[string]*tenantCaches)
+			}
+		}
+	case defaultTenant:
+		dropTenant(defaultCaches)
+	default:
+		if caches, ok := tenants[tenant]; ok {
+			dropTenant(caches)
+			if !dryRun && scope == scopeAll {
+				delete(tenants, tenant)
+			}
+		}
+	}
+	return entries
+}
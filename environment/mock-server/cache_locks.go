@@ -0,0 +1,41 @@
+package main
+
+import "sync"
+
+// Each success cache has its own lock so ES, IDB and PGI traffic don't
+// contend with each other; cacheMutex only guards configuration and the
+// remaining simulator state. When more than one lock is needed they are
+// always taken in this order, which rules out deadlocks:
+//
+//	cacheMutex -> gatewayCacheMutex -> idbCacheMutex -> pgiCacheMutex
+var (
+	gatewayCacheMutex sync.RWMutex // gatewayCache, customerGatewayCache
+	idbCacheMutex     sync.RWMutex // idbSuccessSet, idbIdempotencyKeys, idbNotifiedAt (of every tenant)
+	pgiCacheMutex     sync.RWMutex // pgiSuccessSet
+)
+
+// lockCaches write-locks all three caches for changes that span them.
+func lockCaches() {
+	gatewayCacheMutex.Lock()
+	idbCacheMutex.Lock()
+	pgiCacheMutex.Lock()
+}
+
+func unlockCaches() {
+	pgiCacheMutex.Unlock()
+	idbCacheMutex.Unlock()
+	gatewayCacheMutex.Unlock()
+}
+
+// rlockCaches read-locks all three caches so a dump sees one consistent state.
+func rlockCaches() {
+	gatewayCacheMutex.RLock()
+	idbCacheMutex.RLock()
+	pgiCacheMutex.RLock()
+}
+
+func runlockCaches() {
+	pgiCacheMutex.RUnlock()
+	idbCacheMutex.RUnlock()
+	gatewayCacheMutex.RUnlock()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clockNow is the source of every simulated timestamp: response timestamps,
+// cache ages and TTLs, payment lifecycle dwell times, fault bursts and
+// creation times. Code can swap it for a fixed function; at runtime
+// /admin/clock freezes or offsets it. Durations the server measures for
+// itself (latency metrics, uptime, rate limiting, TLS and webhook signature
+// times) stay on the real clock.
+var clockNow = simulatedNow
+
+var (
+	clockMutex  sync.RWMutex
+	clockFrozen *time.Time    // when set, the clock stands still at this time
+	clockOffset time.Duration // added to the real time while running
+
+	// Added only to the timestamps written into responses, on top of the
+	// simulated clock, to mimic a gateway whose clock drifts from ours
+	// (adjustable via /admin/clock-skew)
+	clockSkew time.Duration
+)
+
+func simulatedNow() time.Time {
+	clockMutex.RLock()
+	defer clockMutex.RUnlock()
+	if clockFrozen != nil {
+		return *clockFrozen
+	}
+	return time.Now().Add(clockOffset)
+}
+
+type clockConfig struct {
+	Now      time.Time `json:"now"`
+	Frozen   bool      `json:"frozen"`
+	OffsetMs int64     `json:"offsetMs"` // from the real time; 0 while frozen
+}
+
+func handleGetClock(w http.ResponseWriter, _ *http.Request) {
+	clockMutex.RLock()
+	config := clockConfig{Frozen: clockFrozen != nil, OffsetMs: clockOffset.Milliseconds()}
+	clockMutex.RUnlock()
+	config.Now = clockNow().UTC()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetClock freezes, offsets or advances the simulated clock, e.g.
+// {"freezeAt":"2024-01-01T00:00:00Z"}, {"freeze":true} (stop at the current
+// simulated time), {"offsetMs":3600000} (run an hour ahead, unfrozen) or
+// {"advanceMs":60000} (jump forward a minute, frozen or not). One action per
+// request.
+func handleSetClock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		FreezeAt  *time.Time `json:"freezeAt"`
+		Freeze    bool       `json:"freeze"`
+		OffsetMs  *int64     `json:"offsetMs"`
+		AdvanceMs *int64     `json:"advanceMs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	actions := 0
+	for _, set := range []bool{req.FreezeAt != nil, req.Freeze, req.OffsetMs != nil, req.AdvanceMs != nil} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+			"exactly one of freezeAt, freeze, offsetMs or advanceMs is required")
+		return
+	}
+	if req.AdvanceMs != nil && *req.AdvanceMs < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "advanceMs must not be negative")
+		return
+	}
+
+	clockMutex.Lock()
+	switch {
+	case req.FreezeAt != nil:
+		frozen := *req.FreezeAt
+		clockFrozen, clockOffset = &frozen, 0
+	case req.Freeze:
+		if clockFrozen == nil {
+			frozen := time.Now().Add(clockOffset)
+			clockFrozen, clockOffset = &frozen, 0
+		}
+	case req.OffsetMs != nil:
+		clockFrozen, clockOffset = nil, time.Duration(*req.OffsetMs)*time.Millisecond
+	case req.AdvanceMs != nil:
+		step := time.Duration(*req.AdvanceMs) * time.Millisecond
+		if clockFrozen != nil {
+			frozen := clockFrozen.Add(step)
+			clockFrozen = &frozen
+		} else {
+			clockOffset += step
+		}
+	}
+	clockMutex.Unlock()
+
+	logger.Info("Clock updated", "endpoint", "admin", "now", clockNow().UTC())
+
+	handleGetClock(w, r)
+}
+
+// handleResetClock puts the simulated clock back on real time.
+func handleResetClock(w http.ResponseWriter, r *http.Request) {
+	clockMutex.Lock()
+	clockFrozen, clockOffset = nil, 0
+	clockMutex.Unlock()
+
+	logger.Info("Clock reset to real time", "endpoint", "admin")
+
+	handleGetClock(w, r)
+}
+
+// currentClockSkew returns the skew applied to response timestamps.
+func currentClockSkew() time.Duration {
+	clockMutex.RLock()
+	defer clockMutex.RUnlock()
+	return clockSkew
+}
+
+type clockSkewConfig struct {
+	OffsetMs int64 `json:"offsetMs"`
+}
+
+func handleGetClockSkew(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(clockSkewConfig{OffsetMs: currentClockSkew().Milliseconds()})
+}
+
+// handleSetClockSkew shifts every response timestamp by offsetMs, e.g.
+// {"offsetMs":5000} makes IDB and PGI report times 5 seconds in the future;
+// negative values put them in the past and 0 turns skew off. Cache TTLs,
+// dwell times and other internal timing are unaffected.
+func handleSetClockSkew(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OffsetMs *int64 `json:"offsetMs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.OffsetMs == nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "offsetMs is required")
+		return
+	}
+
+	clockMutex.Lock()
+	clockSkew = time.Duration(*req.OffsetMs) * time.Millisecond
+	clockMutex.Unlock()
+
+	logger.Info("Clock skew updated", "endpoint", "admin", "offsetMs", *req.OffsetMs)
+
+	handleGetClockSkew(w, r)
+}
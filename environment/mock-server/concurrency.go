@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Concurrency cap for business endpoints (MAX_INFLIGHT, adjustable via
+// /admin/concurrency). Requests over the cap are turned away with 503 at once
+// instead of queueing, so clients see real backpressure. Admin and metrics
+// routes are never limited.
+var (
+	maxInFlight      atomic.Int64 // 0 means unlimited
+	businessInFlight atomic.Int64
+	inFlightRejected atomic.Int64
+)
+
+// acquireSlot claims a concurrency slot, reporting false when the cap is
+// reached. Callers must call releaseSlot after a successful acquire.
+func acquireSlot() bool {
+	n := businessInFlight.Add(1)
+	if limit := maxInFlight.Load(); limit > 0 && n > limit {
+		businessInFlight.Add(-1)
+		inFlightRejected.Add(1)
+		return false
+	}
+	return true
+}
+
+func releaseSlot() {
+	businessInFlight.Add(-1)
+}
+
+func writeOverCapacity(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	writeProblem(w, http.StatusServiceUnavailable, codeOverCapacity, "Service Unavailable", "Too many concurrent requests")
+}
+
+type concurrencyStats struct {
+	MaxInFlight int64 `json:"maxInFlight"`
+	InFlight    int64 `json:"inFlight"`
+	Rejected    int64 `json:"rejected"`
+}
+
+func handleGetConcurrency(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(concurrencyStats{
+		MaxInFlight: maxInFlight.Load(),
+		InFlight:    businessInFlight.Load(),
+		Rejected:    inFlightRejected.Load(),
+	})
+}
+
+// handleSetConcurrency changes the cap, e.g. {"maxInFlight":50}. 0 removes
+// it. Requests already running are unaffected.
+func handleSetConcurrency(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MaxInFlight int64 `json:"maxInFlight"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.MaxInFlight < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "maxInFlight must not be negative")
+		return
+	}
+
+	maxInFlight.Store(req.MaxInFlight)
+
+	logger.Info("Concurrency limit updated", "endpoint", "admin", "maxInFlight", req.MaxInFlight)
+
+	handleGetConcurrency(w, r)
+}
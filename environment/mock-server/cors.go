@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Origins allowed to call the mock from a browser (CORS_ORIGINS, comma-separated)
+var corsOrigins = []string{"*"}
+
+const (
+	corsAllowedMethods = "GET, POST, DELETE, HEAD, OPTIONS"
+	corsAllowedHeaders = "Content-Type, Authorization, X-Admin-Key, X-Gateway-Name, X-Client-Id, X-Force-Error, X-Force-Success, X-Customer-Id, X-Hang-Ms, X-Reset, X-Allow-Duplicates, Idempotency-Key, If-None-Match, X-Request-Id, X-Region, X-Tenant-Id, X-Confirm, traceparent, tracestate"
+	corsExposedHeaders = "Retry-After, Idempotent-Replayed, Content-Disposition, ETag, X-Request-Id, traceparent, tracestate"
+)
+
+// withCORS sets CORS headers on every response and answers preflight
+// OPTIONS requests with 204 before they reach routing or admin auth.
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		switch {
+		case slices.Contains(corsOrigins, "*"):
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && slices.Contains(corsOrigins, origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+		w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func parseCORSOrigins(value string) []string {
+	var origins []string
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Dispute lifecycle: a succeeded or captured payment moves to disputed when a
+// dispute opens. Resolving it won returns the payment to its previous status;
+// lost ends it in charged_back.
+const (
+	disputeOpen = "open"
+	disputeWon  = "won"
+	disputeLost = "lost"
+)
+
+// Reason codes a dispute may be opened with; general when none is given.
+var disputeReasons = []string{
+	"general", "fraudulent", "duplicate", "product_not_received",
+	"product_unacceptable", "credit_not_processed", "unrecognized",
+}
+
+type dispute struct {
+	PaymentId      string     `json:"paymentId"`
+	Reason         string     `json:"reason"`
+	Status         string     `json:"status"`
+	Amount         int64      `json:"amount"`
+	Currency       string     `json:"currency"`
+	PreviousStatus string     `json:"previousStatus"` // payment status before the dispute
+	OpenedAt       time.Time  `json:"openedAt"`
+	ResolvedAt     *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// Dispute per payment (guarded by cacheMutex). A payment can be disputed
+// once; the entry stays after resolution.
+var disputes = make(map[string]*dispute)
+
+func writeDispute(w http.ResponseWriter, status int, d dispute) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(d)
+}
+
+// handlePgiDispute opens a dispute on a succeeded or captured payment, e.g.
+// {"reason":"fraudulent"}, and answers 201 with the dispute. Payments in any
+// other status, including one already disputed, get a 409.
+func handlePgiDispute(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+	if !validatePaymentId(w, paymentId) {
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "general"
+	}
+	if !slices.Contains(disputeReasons, req.Reason) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+			"reason must be one of "+strings.Join(disputeReasons, ", "))
+		return
+	}
+
+	reqLog := requestLogger(r, "pgi_dispute", paymentId, "")
+	reqLog.Debug("Dispute requested", "reason", req.Reason)
+
+	if connectionFault(w, r, reqLog, "pgi_dispute") || handleForcedError(w, r, reqLog, "pgi_dispute", codePgiInternal) {
+		return
+	}
+
+	now := clockNow()
+	cacheMutex.Lock()
+	state, ok := paymentStates[paymentId]
+	if !ok || (state.Status != statusSucceeded && state.Status != statusCaptured) {
+		current := "unknown"
+		if ok {
+			current = state.Status
+		}
+		cacheMutex.Unlock()
+		reqLog.Warn("Dispute rejected", "paymentStatus", current)
+		writeProblem(w, http.StatusConflict, codeInvalidPaymentState, "Conflict",
+			"Payment '"+paymentId+"' is "+current+", only succeeded or captured payments can be disputed")
+		return
+	}
+	if _, exists := disputes[paymentId]; exists {
+		cacheMutex.Unlock()
+		reqLog.Warn("Dispute rejected", "reason", "already disputed")
+		writeProblem(w, http.StatusConflict, codeInvalidPaymentState, "Conflict",
+			"Payment '"+paymentId+"' has already been disputed")
+		return
+	}
+	details := lookupPaymentDetails(paymentId)
+	d := &dispute{
+		PaymentId:      paymentId,
+		Reason:         req.Reason,
+		Status:         disputeOpen,
+		Amount:         details.Amount,
+		Currency:       details.Currency,
+		PreviousStatus: state.Status,
+		OpenedAt:       now,
+	}
+	disputes[paymentId] = d
+	state.Status = statusDisputed
+	state.UpdatedAt = now
+	opened := *d
+	cacheMutex.Unlock()
+
+	reqLog.Info("Dispute opened", "reason", req.Reason)
+
+	simulateLatency(r, "pgi")
+	writeDispute(w, http.StatusCreated, opened)
+}
+
+// handleGetPgiDispute returns the payment's dispute, or 404 if it has none.
+func handleGetPgiDispute(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+	if !validatePaymentId(w, paymentId) {
+		return
+	}
+
+	cacheMutex.RLock()
+	d, ok := disputes[paymentId]
+	var found dispute
+	if ok {
+		found = *d
+	}
+	cacheMutex.RUnlock()
+
+	if !ok {
+		writeProblem(w, http.StatusNotFound, codeDisputeNotFound, "Not Found", "Payment '"+paymentId+"' has no dispute")
+		return
+	}
+	writeDispute(w, http.StatusOK, found)
+}
+
+// handleResolvePgiDispute settles an open dispute, e.g. {"outcome":"won"}.
+// Won puts the payment back in the status it had before the dispute; lost
+// moves it to charged_back. Resolving twice is a 409.
+func handleResolvePgiDispute(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+	if !validatePaymentId(w, paymentId) {
+		return
+	}
+
+	var req struct {
+		Outcome string `json:"outcome"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.Outcome != disputeWon && req.Outcome != disputeLost {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "outcome must be won or lost")
+		return
+	}
+
+	reqLog := requestLogger(r, "pgi_dispute", paymentId, "")
+	reqLog.Debug("Dispute resolution requested", "outcome", req.Outcome)
+
+	if connectionFault(w, r, reqLog, "pgi_dispute") || handleForcedError(w, r, reqLog, "pgi_dispute", codePgiInternal) {
+		return
+	}
+
+	now := clockNow()
+	cacheMutex.Lock()
+	d, ok := disputes[paymentId]
+	if !ok {
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusNotFound, codeDisputeNotFound, "Not Found", "Payment '"+paymentId+"' has no dispute")
+		return
+	}
+	if d.Status != disputeOpen {
+		current := d.Status
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusConflict, codeInvalidPaymentState, "Conflict",
+			"Dispute for payment '"+paymentId+"' is already "+current)
+		return
+	}
+	d.Status = req.Outcome
+	d.ResolvedAt = &now
+	status := statusChargedBack
+	if req.Outcome == disputeWon {
+		status = d.PreviousStatus
+	}
+	// Keep the rest of the state, e.g. the poll count
+	state, ok := paymentStates[paymentId]
+	if !ok {
+		state = &paymentState{}
+		paymentStates[paymentId] = state
+	}
+	state.Status, state.UpdatedAt = status, now
+	resolved := *d
+	cacheMutex.Unlock()
+
+	reqLog.Info("Dispute resolved", "outcome", req.Outcome, "paymentStatus", status)
+
+	simulateLatency(r, "pgi")
+	writeDispute(w, http.StatusOK, resolved)
+}
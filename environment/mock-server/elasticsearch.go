@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// handleElasticsearchHead checks that a payment exists without fetching it:
+// 404 when the GET would report it not found (marked missing, or not yet
+// visible), else 200. Every other payment would resolve, so it neither rolls
+// for an injected error nor assigns a gateway. The body is always empty.
+func handleElasticsearchHead(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+	if !validatePaymentId(w, paymentId) {
+		return
+	}
+
+	reqLog := requestLogger(r, "es_head", paymentId, "")
+	reqLog.Debug("Checking existence")
+
+	if connectionFault(w, r, reqLog, "es_head") || handleForcedError(w, r, reqLog, "es_head", codeEsInternal) {
+		return
+	}
+
+	found := !paymentMissing(paymentId) && !gatewayPending(requestCaches(r).gateway, paymentId, clockNow())
+
+	simulateLatency(r, "es")
+	w.Header().Set("Content-Type", "application/json")
+	if !found {
+		reqLog.Debug("Payment not found")
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// handleElasticsearchMget looks up many payments at once, e.g.
+// {"ids":["pay_1","pay_2"]}. Each id hits or misses the cache independently
+// and injected errors apply per document, so a batch can partially fail.
+func handleElasticsearchMget(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ids []string `json:"ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if len(req.Ids) == 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "ids must not be empty")
+		return
+	}
+	for _, paymentId := range req.Ids {
+		if !validatePaymentId(w, paymentId) {
+			return
+		}
+	}
+
+	batchLog := requestLogger(r, "es_mget", "", "")
+	batchLog.Debug("Multi-get", "count", len(req.Ids))
+
+	if connectionFault(w, r, batchLog, "es_mget") || handleForcedError(w, r, batchLog, "es_mget", codeEsInternal) {
+		return
+	}
+
+	forceSuccess := forceSuccessRequested(r)
+	customerId := r.Header.Get("X-Customer-Id")
+	docs := make([]map[string]any, 0, len(req.Ids))
+	for _, paymentId := range req.Ids {
+		if paymentMissing(paymentId) || gatewayPending(requestCaches(r).gateway, paymentId, clockNow()) {
+			docs = append(docs, notFoundDocument(paymentId))
+			continue
+		}
+		gateway, ok := lookupGateway(requestCaches(r).gateway, paymentId, customerId, forceSuccess, requestLogger(r, "es_mget", paymentId, ""))
+		if !ok {
+			docs = append(docs, map[string]any{
+				"_index": "payments",
+				"_id":    paymentId,
+				"error": map[string]string{
+					"type":   "internal_error",
+					"reason": "Elasticsearch internal error",
+				},
+			})
+			continue
+		}
+		doc := paymentDocument(paymentId, gateway)
+		doc["found"] = true
+		docs = append(docs, doc)
+	}
+
+	simulateLatency(r, "es")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"docs": docs})
+}
+
+// handleElasticsearchSearch runs a minimal query over cached payments. Only
+// match_all (or no query) and a single term query on a _source field are
+// supported, e.g. {"query":{"term":{"gatewayName":"stripe"}},"size":10}.
+func handleElasticsearchSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query struct {
+			Term     map[string]any `json:"term"`
+			MatchAll *struct{}      `json:"match_all"`
+		} `json:"query"`
+		From int  `json:"from"`
+		Size *int `json:"size"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if len(req.Query.Term) > 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "term query supports a single field")
+		return
+	}
+
+	size := 10
+	if req.Size != nil {
+		size = *req.Size
+	}
+	if req.From < 0 || size < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "from and size must not be negative")
+		return
+	}
+
+	start := time.Now()
+	reqLog := requestLogger(r, "es_search", "", "")
+	reqLog.Debug("Search", "term", req.Query.Term)
+
+	if connectionFault(w, r, reqLog, "es_search") || handleForcedError(w, r, reqLog, "es_search", codeEsInternal) {
+		return
+	}
+
+	// Snapshot the live, visible entries first; building documents takes the
+	// lock again
+	type cached struct{ paymentId, gateway string }
+	var entries []cached
+	now := clockNow()
+	ttl := currentGatewayCacheTTL()
+	delay := currentEsConsistencyDelay()
+	gatewayCacheMutex.RLock()
+	requestCaches(r).gateway.Range(func(paymentId string, entry gatewayEntry) {
+		if !entry.expired(now, ttl) && entry.visible(now, delay) {
+			entries = append(entries, cached{paymentId, entry.Gateway})
+		}
+	})
+	gatewayCacheMutex.RUnlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].paymentId < entries[j].paymentId })
+
+	hits := make([]map[string]any, 0)
+	for _, e := range entries {
+		doc := paymentDocument(e.paymentId, e.gateway)
+		if !matchesTerm(doc["_source"].(map[string]any), req.Query.Term) {
+			continue
+		}
+		doc["_score"] = 1.0
+		hits = append(hits, doc)
+	}
+
+	// Clamp without adding from and size, which may overflow
+	total := len(hits)
+	first := min(req.From, total)
+	page := hits[first : first+min(size, total-first)]
+
+	simulateLatency(r, "es")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"took":      time.Since(start).Milliseconds(),
+		"timed_out": false,
+		"hits": map[string]any{
+			"total":     map[string]any{"value": total, "relation": "eq"},
+			"max_score": 1.0,
+			"hits":      page,
+		},
+	})
+}
+
+// matchesTerm reports whether the single term (if any) equals the source
+// field, comparing string forms so numbers and strings both work.
+func matchesTerm(source map[string]any, term map[string]any) bool {
+	for field, want := range term {
+		// Accept the long form {"field":{"value":...}} too
+		if long, ok := want.(map[string]any); ok {
+			want = long["value"]
+		}
+		got, ok := source[field]
+		if !ok || fmt.Sprint(got) != fmt.Sprint(want) {
+			return false
+		}
+	}
+	return true
+}
+
+// documentETag derives a strong ETag from the encoded document. The gateway
+// is stable once cached, so it only changes when the payment's status or
+// details do.
+func documentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag,
+// handling "*", comma-separated lists and weak (W/) validators.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
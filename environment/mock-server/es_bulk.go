@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Longest NDJSON line a bulk request may carry
+const maxBulkLine = 1 << 20
+
+// bulkOp is one action of a bulk request with its source, if any.
+type bulkOp struct {
+	action string // index, create or delete
+	index  string
+	id     string
+	seed   gatewaySeed
+	err    *bulkError // set when the op fails validation and is not applied
+}
+
+type bulkError struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// bulkItem is one entry of a bulk response, keyed by its action.
+type bulkItem struct {
+	Index  string     `json:"_index"`
+	Id     string     `json:"_id"`
+	Result string     `json:"result,omitempty"`
+	Status int        `json:"status"`
+	Error  *bulkError `json:"error,omitempty"`
+}
+
+// bulkSource is the _source of an index or create action: the document the
+// GET serves. gatewayName is required; the rest override the derived
+// values as with POST /admin/cache/gateway.
+type bulkSource struct {
+	PaymentId   string     `json:"paymentId"`
+	GatewayName string     `json:"gatewayName"`
+	Status      string     `json:"status"`
+	Amount      *int64     `json:"amount"`
+	Currency    string     `json:"currency"`
+	CreatedAt   *time.Time `json:"createdAt"`
+}
+
+// handleElasticsearchBulk indexes payments from Elasticsearch's NDJSON bulk
+// format, e.g.
+//
+//	{"index":{"_index":"payments","_id":"pay_1"}}
+//	{"gatewayName":"adyen","status":"succeeded"}
+//
+// index and create pin the payment to gatewayName in the tenant's gateway
+// cache (create fails if it is already cached); delete drops it. Items fail
+// independently and are reported in the standard bulk response. Entries are
+// subject to the consistency delay unless ?refresh=true or wait_for.
+func handleElasticsearchBulk(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	reqLog := requestLogger(r, "es_bulk", "", "")
+
+	if connectionFault(w, r, reqLog, "es_bulk") || handleForcedError(w, r, reqLog, "es_bulk", codeEsInternal) {
+		return
+	}
+
+	ops, reason := parseBulk(r)
+	if reason != "" {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", reason)
+		return
+	}
+	reqLog.Debug("Bulk request", "count", len(ops))
+
+	known := currentGateways()
+	pattern := currentPaymentIdPattern()
+	for i := range ops {
+		op := &ops[i]
+		switch {
+		case op.err != nil:
+		case op.index == "":
+			op.err = &bulkError{"action_request_validation_exception", "index is missing"}
+		case op.index != "payments":
+			op.err = &bulkError{"index_not_found_exception", "no such index [" + op.index + "]"}
+		case op.id == "":
+			op.err = &bulkError{"action_request_validation_exception", "an id is required"}
+		case !pattern.MatchString(op.id):
+			op.err = &bulkError{"illegal_argument_exception", "invalid payment ID " + strconv.Quote(op.id) + ": must match " + pattern.String()}
+		case op.action != "delete":
+			if reason := op.seed.invalid(known); reason != "" {
+				op.err = &bulkError{"mapper_parsing_exception", reason}
+			}
+		}
+	}
+
+	// A refresh makes the documents visible at once, as if cached a full
+	// consistency delay ago
+	now := clockNow()
+	cachedAt := now
+	if query := r.URL.Query(); query.Has("refresh") && query.Get("refresh") != "false" {
+		cachedAt = now.Add(-currentEsConsistencyDelay())
+	}
+
+	cache := requestCaches(r).gateway
+	ttl := currentGatewayCacheTTL()
+	items := make([]map[string]bulkItem, 0, len(ops))
+	failed := false
+	cacheMutex.Lock()
+	gatewayCacheMutex.Lock()
+	for _, op := range ops {
+		item := bulkItem{Index: op.index, Id: op.id}
+		entry, exists := cache.Peek(op.id)
+		exists = exists && !entry.expired(now, ttl)
+		switch {
+		case op.err != nil:
+			item.Status, item.Error = bulkErrorStatus(op.err), op.err
+		case op.action == "delete" && !exists:
+			item.Result, item.Status = "not_found", http.StatusNotFound
+		case op.action == "delete":
+			cache.Remove(op.id)
+			item.Result, item.Status = "deleted", http.StatusOK
+		case op.action == "create" && exists:
+			item.Error = &bulkError{"version_conflict_engine_exception", "[" + op.id + "]: version conflict, document already exists"}
+			item.Status = http.StatusConflict
+		default:
+			op.seed.apply(cache, cachedAt)
+			item.Result, item.Status = "created", http.StatusCreated
+			if exists {
+				item.Result, item.Status = "updated", http.StatusOK
+			}
+		}
+		failed = failed || item.Error != nil
+		items = append(items, map[string]bulkItem{op.action: item})
+	}
+	gatewayCacheMutex.Unlock()
+	cacheMutex.Unlock()
+
+	reqLog.Debug("Bulk applied", "count", len(ops), "errors", failed)
+
+	simulateLatency(r, "es")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"took":   time.Since(start).Milliseconds(),
+		"errors": failed,
+		"items":  items,
+	})
+}
+
+// parseBulk reads the action and source lines of a bulk body. Malformed
+// action lines fail the whole request, as in Elasticsearch, with the reason
+// returned; a malformed source only fails its item.
+func parseBulk(r *http.Request) ([]bulkOp, string) {
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxBulkLine)
+	line := 0
+	next := func() ([]byte, bool) {
+		for scanner.Scan() {
+			line++
+			if text := bytes.TrimSpace(scanner.Bytes()); len(text) > 0 {
+				return text, true
+			}
+		}
+		return nil, false
+	}
+
+	var ops []bulkOp
+	for {
+		text, ok := next()
+		if !ok {
+			break
+		}
+		var action map[string]struct {
+			Index string `json:"_index"`
+			Id    string `json:"_id"`
+		}
+		if err := json.Unmarshal(text, &action); err != nil || len(action) != 1 {
+			return nil, "Malformed action/metadata line [" + strconv.Itoa(line) + "], expected a single action"
+		}
+		var op bulkOp
+		for name, meta := range action {
+			op = bulkOp{action: name, index: meta.Index, id: meta.Id}
+		}
+		switch op.action {
+		case "delete":
+			ops = append(ops, op)
+			continue
+		case "index", "create":
+		default:
+			return nil, "Unsupported action [" + op.action + "] on line [" + strconv.Itoa(line) + "], expected index, create or delete"
+		}
+
+		text, ok = next()
+		if !ok {
+			return nil, "Missing source for the action on line [" + strconv.Itoa(line) + "]"
+		}
+		var source bulkSource
+		if err := json.Unmarshal(text, &source); err != nil {
+			op.err = &bulkError{"mapper_parsing_exception", "failed to parse source on line [" + strconv.Itoa(line) + "]"}
+		}
+		if op.id == "" {
+			op.id = source.PaymentId
+		}
+		op.seed = gatewaySeed{
+			PaymentId: op.id,
+			Gateway:   source.GatewayName,
+			Status:    source.Status,
+			Amount:    source.Amount,
+			Currency:  source.Currency,
+			CreatedAt: source.CreatedAt,
+		}
+		ops = append(ops, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "Invalid request body: " + err.Error()
+	}
+	if len(ops) == 0 {
+		return nil, "request body is required"
+	}
+	return ops, ""
+}
+
+// bulkErrorStatus is the item status Elasticsearch reports for err.
+func bulkErrorStatus(err *bulkError) int {
+	if err.Type == "index_not_found_exception" {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
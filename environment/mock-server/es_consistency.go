@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// How long a newly cached gateway stays invisible to Elasticsearch reads,
+// like a document written but not yet refreshed (guarded by cacheMutex,
+// adjustable via ES_CONSISTENCY_DELAY or /admin/es-consistency). The read
+// that assigns the gateway still returns it; reads after it get 404
+// found:false until the delay has passed. 0, the default, disables it.
+var esConsistencyDelay time.Duration
+
+func currentEsConsistencyDelay() time.Duration {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return esConsistencyDelay
+}
+
+// visible reports whether the entry can be read delay after it was cached.
+func (e gatewayEntry) visible(now time.Time, delay time.Duration) bool {
+	return now.Sub(e.CachedAt) >= delay
+}
+
+// gatewayPending reports whether paymentId's gateway was cached in cache too
+// recently to be read yet.
+func gatewayPending(cache *lruCache[gatewayEntry], paymentId string, now time.Time) bool {
+	delay := currentEsConsistencyDelay()
+	if delay <= 0 {
+		return false
+	}
+
+	gatewayCacheMutex.RLock()
+	entry, cached := cache.Peek(paymentId)
+	gatewayCacheMutex.RUnlock()
+	return cached && !entry.visible(now, delay)
+}
+
+func handleGetEsConsistency(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"delayMs": currentEsConsistencyDelay().Milliseconds()})
+}
+
+// handleSetEsConsistency sets the consistency delay, e.g. {"delayMs":1000}.
+// 0 disables it.
+func handleSetEsConsistency(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DelayMs int64 `json:"delayMs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.DelayMs < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "delayMs must not be negative")
+		return
+	}
+
+	cacheMutex.Lock()
+	esConsistencyDelay = time.Duration(req.DelayMs) * time.Millisecond
+	cacheMutex.Unlock()
+
+	logger.Info("ES consistency delay updated", "endpoint", "admin", "delayMs", req.DelayMs)
+
+	handleGetEsConsistency(w, r)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// faultBurst overrides one endpoint's error rate for a time window.
+type faultBurst struct {
+	Endpoint string    `json:"endpoint"`
+	Rate     float64   `json:"rate"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+}
+
+// Scheduled bursts in start order (guarded by cacheMutex, adjustable via
+// /admin/fault-burst). Bursts for the same endpoint queue back to back;
+// expired ones are dropped lazily. Their windows run on wall time, not the
+// simulated clock, so freezing or advancing it via /admin/clock neither
+// stalls nor skips a burst.
+var faultBursts []faultBurst
+
+// burstEndpoints are the error rates a burst can override.
+var burstEndpoints = []string{"es", "idb", "pgi"}
+
+// burstErrorRate returns the endpoint's error rate: the active burst's rate if
+// one is running, otherwise base.
+func burstErrorRate(endpoint string, base float64) float64 {
+	now := time.Now()
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+
+	for _, burst := range faultBursts {
+		if burst.Endpoint == endpoint && !now.Before(burst.Start) && now.Before(burst.End) {
+			return burst.Rate
+		}
+	}
+	return base
+}
+
+// pruneFaultBursts drops bursts that have ended. Caller must hold cacheMutex.
+func pruneFaultBursts(now time.Time) {
+	faultBursts = slices.DeleteFunc(faultBursts, func(burst faultBurst) bool {
+		return !now.Before(burst.End)
+	})
+}
+
+type faultBurstView struct {
+	faultBurst
+	Active bool `json:"active"`
+}
+
+func handleGetFaultBursts(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	cacheMutex.Lock()
+	pruneFaultBursts(now)
+	bursts := make([]faultBurstView, 0, len(faultBursts))
+	for _, burst := range faultBursts {
+		bursts = append(bursts, faultBurstView{faultBurst: burst, Active: !now.Before(burst.Start)})
+	}
+	cacheMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"bursts": bursts})
+}
+
+// handleAddFaultBurst schedules a burst, e.g.
+// {"endpoint":"pgi","rate":1.0,"durationMs":30000}. It starts now, or when
+// the last burst already queued for that endpoint ends; once over, the
+// endpoint's normal error rate applies again.
+func handleAddFaultBurst(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Endpoint   string  `json:"endpoint"`
+		Rate       float64 `json:"rate"`
+		DurationMs int64   `json:"durationMs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if !slices.Contains(burstEndpoints, req.Endpoint) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "endpoint must be one of es, idb, pgi")
+		return
+	}
+	if req.Rate < 0 || req.Rate > 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "rate must be between 0 and 1")
+		return
+	}
+	if req.DurationMs <= 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "durationMs must be positive")
+		return
+	}
+
+	now := time.Now()
+	cacheMutex.Lock()
+	pruneFaultBursts(now)
+	start := now
+	for _, queued := range faultBursts {
+		if queued.Endpoint == req.Endpoint && queued.End.After(start) {
+			start = queued.End
+		}
+	}
+	burst := faultBurst{
+		Endpoint: req.Endpoint,
+		Rate:     req.Rate,
+		Start:    start,
+		End:      start.Add(time.Duration(req.DurationMs) * time.Millisecond),
+	}
+	faultBursts = append(faultBursts, burst)
+	cacheMutex.Unlock()
+
+	logger.Info("Fault burst scheduled", "endpoint", "admin", "target", burst.Endpoint, "rate", burst.Rate,
+		"start", burst.Start.UTC().Format(time.RFC3339Nano), "end", burst.End.UTC().Format(time.RFC3339Nano))
+
+	handleGetFaultBursts(w, r)
+}
+
+// handleClearFaultBursts cancels all running and queued bursts.
+func handleClearFaultBursts(w http.ResponseWriter, r *http.Request) {
+	cacheMutex.Lock()
+	faultBursts = nil
+	cacheMutex.Unlock()
+
+	logger.Info("Fault bursts cleared", "endpoint", "admin")
+
+	handleGetFaultBursts(w, r)
+}
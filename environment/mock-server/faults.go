@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuses recorded for requests that never got a complete response, borrowed
+// from nginx: the client gave up (499) or the server dropped the connection (444).
+const (
+	statusClientClosed = 499
+	statusConnReset    = 444
+)
+
+// Fault probabilities (guarded by cacheMutex)
+var (
+	hangRate  float64 // request hangs until the client gives up
+	resetRate float64 // connection is reset mid-response
+)
+
+// connectionFault applies the opt-in transport-level faults (hangs and
+// resets) ahead of X-Force-Error. It reports whether the request is finished.
+func connectionFault(w http.ResponseWriter, r *http.Request, reqLog *slog.Logger, endpoint string) bool {
+	return hangIfRequested(w, r, reqLog, endpoint) || resetIfRequested(w, r, reqLog, endpoint)
+}
+
+// hangIfRequested simulates an unresponsive upstream. X-Hang-Ms: N stalls the
+// request for N ms before normal handling continues; the configured hang rate
+// stalls it with no upper bound. Either way the wait ends as soon as the
+// client disconnects, in which case nothing is written and it reports true so
+// the handler returns without leaking the goroutine. Hung requests are exempt
+// from the server timeouts.
+func hangIfRequested(w http.ResponseWriter, r *http.Request, reqLog *slog.Logger, endpoint string) bool {
+	var hang time.Duration // 0 hangs until the client disconnects
+	errorType := errorForced
+	if value := r.Header.Get("X-Hang-Ms"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms <= 0 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "X-Hang-Ms must be a positive number of milliseconds")
+			return true
+		}
+		hang = time.Duration(ms) * time.Millisecond
+	} else {
+		cacheMutex.RLock()
+		rate := hangRate
+		cacheMutex.RUnlock()
+		if !rollFailure(rate) {
+			return false
+		}
+		errorType = errorInjected
+	}
+
+	reqLog.Warn("Hanging request", "hangMs", hang.Milliseconds())
+	exemptFromTimeouts(w)
+
+	var elapsed <-chan time.Time
+	if hang > 0 {
+		timer := time.NewTimer(hang)
+		defer timer.Stop()
+		elapsed = timer.C
+	}
+
+	select {
+	case <-elapsed:
+		return false
+	case <-r.Context().Done():
+		reqLog.Debug("Client gave up on hung request")
+		recordError(endpoint, errorType)
+		markErrorInjected(w)
+		if rec, ok := w.(*statusRecorder); ok {
+			rec.status = statusClientClosed
+		}
+		return true
+	}
+}
+
+// resetIfRequested drops the connection without a complete response when the
+// request carries X-Reset: true or the reset rate fires. It hijacks the
+// socket, writes half a status line and headers so the client sees a truncated
+// response, then closes with SO_LINGER 0 so the peer gets a TCP RST rather
+// than a clean FIN. It reports whether the connection was dropped.
+func resetIfRequested(w http.ResponseWriter, r *http.Request, reqLog *slog.Logger, endpoint string) bool {
+	errorType := errorForced
+	if !strings.EqualFold(r.Header.Get("X-Reset"), "true") {
+		cacheMutex.RLock()
+		rate := resetRate
+		cacheMutex.RUnlock()
+		if !rollFailure(rate) {
+			return false
+		}
+		errorType = errorInjected
+	}
+
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 and some wrappers can't be hijacked; carry on with a normal response
+		reqLog.Warn("Connection reset unsupported, skipping", "err", err)
+		return false
+	}
+
+	reqLog.Warn("Resetting connection")
+	recordError(endpoint, errorType)
+	markErrorInjected(w)
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.status = statusConnReset
+	}
+
+	buf.WriteString("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n")
+	buf.Flush()
+
+	raw := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		raw = tlsConn.NetConn()
+	}
+	if tcpConn, ok := raw.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	raw.Close()
+	return true
+}
+
+// unavailableConfig decides how injected errors on an endpoint are reported:
+// Ratio of them come back as 503 with Retry-After, the rest as plain 500s.
+type unavailableConfig struct {
+	Ratio         float64 `json:"ratio"`
+	RetryAfterSec int     `json:"retryAfterSec"`
+}
+
+// Per-endpoint 503 split for injected errors (guarded by cacheMutex)
+var unavailableConfigs = map[string]unavailableConfig{
+	"es":  {RetryAfterSec: 1},
+	"idb": {RetryAfterSec: 1},
+	"pgi": {RetryAfterSec: 1},
+}
+
+// writeInjectedError sends a randomly injected error, as a 503 with
+// Retry-After for the endpoint's configured share and a 500 otherwise. The
+// problem body is the same either way.
+func writeInjectedError(w http.ResponseWriter, endpoint, code string) {
+	cacheMutex.RLock()
+	config := unavailableConfigs[endpoint]
+	cacheMutex.RUnlock()
+
+	markErrorInjected(w)
+	status := http.StatusInternalServerError
+	if config.Ratio > 0 && randFloat64() < config.Ratio {
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", strconv.Itoa(config.RetryAfterSec))
+	}
+	writeProblem(w, status, code, internalErrorTitles[code], "Randomly injected error (not cached, a retry may succeed)")
+}
+
+func handleGetUnavailable(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	configs := maps.Clone(unavailableConfigs)
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configs)
+}
+
+// handleSetUnavailable sets the 503 split per endpoint, e.g.
+// {"pgi":{"ratio":0.5,"retryAfterSec":3}}. Endpoints not mentioned are left
+// unchanged; a mentioned endpoint is replaced as a whole.
+func handleSetUnavailable(w http.ResponseWriter, r *http.Request) {
+	var req map[string]unavailableConfig
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	for endpoint, config := range req {
+		if _, ok := unavailableConfigs[endpoint]; !ok {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Unknown endpoint '"+endpoint+"' (expected es, idb or pgi)")
+			return
+		}
+		if config.Ratio < 0 || config.Ratio > 1 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "ratio for '"+endpoint+"' must be between 0 and 1")
+			return
+		}
+		if config.RetryAfterSec < 0 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "retryAfterSec for '"+endpoint+"' must not be negative")
+			return
+		}
+	}
+
+	cacheMutex.Lock()
+	maps.Copy(unavailableConfigs, req)
+	cacheMutex.Unlock()
+
+	logger.Info("Unavailable split updated", "endpoint", "admin", "config", req)
+
+	handleGetUnavailable(w, r)
+}
+
+type faultConfig struct {
+	HangRate  float64 `json:"hangRate"`
+	ResetRate float64 `json:"resetRate"`
+}
+
+func handleGetFaults(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	config := faultConfig{HangRate: hangRate, ResetRate: resetRate}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetFaults updates fault probabilities, e.g.
+// {"hangRate":0.05,"resetRate":0.01}. Omitted fields are left unchanged.
+func handleSetFaults(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		HangRate  *float64 `json:"hangRate"`
+		ResetRate *float64 `json:"resetRate"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	for name, rate := range map[string]*float64{"hangRate": req.HangRate, "resetRate": req.ResetRate} {
+		if rate != nil && (*rate < 0 || *rate > 1) {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", name+" must be between 0 and 1")
+			return
+		}
+	}
+
+	cacheMutex.Lock()
+	if req.HangRate != nil {
+		hangRate = *req.HangRate
+	}
+	if req.ResetRate != nil {
+		resetRate = *req.ResetRate
+	}
+	cacheMutex.Unlock()
+
+	logger.Info("Fault config updated", "endpoint", "admin", "hangRate", req.HangRate, "resetRate", req.ResetRate)
+
+	handleGetFaults(w, r)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// feeRule is a processing fee: percent of the amount plus a fixed part in
+// minor units. A rule with a currency only applies to payments in that
+// currency; one without applies to any currency.
+type feeRule struct {
+	Percent  float64 `json:"percent"`
+	Fixed    int64   `json:"fixed"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// How the percentage part of a fee is rounded to whole minor units, set via
+// FEE_ROUNDING at startup or /admin/fees. Only exact halves differ:
+//
+//	half-up    half a unit rounds up: 12.5 -> 13, 13.5 -> 14 (default)
+//	half-even  half a unit rounds to the even neighbour, also known as
+//	           banker's rounding: 12.5 -> 12, 13.5 -> 14
+//
+// Percentages are taken as the decimals they are written as, so 2.5% of 500
+// is exactly 12.5 and subject to the rounding mode.
+const (
+	roundingHalfUp   = "half-up"
+	roundingHalfEven = "half-even"
+)
+
+var feeRoundingModes = []string{roundingHalfUp, roundingHalfEven}
+
+var (
+	// Fee rules per gateway (guarded by cacheMutex, adjustable via
+	// /admin/fees). Gateways without a matching rule charge nothing.
+	feeSchedule = map[string][]feeRule{
+		"stripe": {{Percent: 2.9, Fixed: 30}},
+		"adyen":  {{Percent: 0.6, Fixed: 12}},
+		"paypal": {{Percent: 3.49, Fixed: 49}},
+	}
+
+	// Guarded by cacheMutex
+	feeRounding = roundingHalfUp
+)
+
+// matchFeeRule picks the gateway's rule for currency: the first rule for
+// exactly that currency, else the first rule without one. With neither, the
+// zero rule charges nothing.
+func matchFeeRule(rules []feeRule, currency string) feeRule {
+	for _, rule := range rules {
+		if strings.EqualFold(rule.Currency, currency) {
+			return rule
+		}
+	}
+	for _, rule := range rules {
+		if rule.Currency == "" {
+			return rule
+		}
+	}
+	return feeRule{}
+}
+
+// fee returns the fee on amount with the percentage rounded per rounding.
+func (r feeRule) fee(amount int64, rounding string) int64 {
+	percent, _ := new(big.Rat).SetString(strconv.FormatFloat(r.Percent, 'f', -1, 64))
+	return roundRat(percent.Mul(percent, big.NewRat(amount, 100)), rounding) + r.Fixed
+}
+
+// roundRat rounds a non-negative x to an integer, resolving exact halves per
+// rounding.
+func roundRat(x *big.Rat, rounding string) int64 {
+	quotient, remainder := new(big.Int).DivMod(x.Num(), x.Denom(), new(big.Int))
+	switch remainder.Lsh(remainder, 1).Cmp(x.Denom()) {
+	case 1:
+		quotient.Add(quotient, big.NewInt(1))
+	case 0:
+		if rounding == roundingHalfUp || quotient.Bit(0) == 1 {
+			quotient.Add(quotient, big.NewInt(1))
+		}
+	}
+	return quotient.Int64()
+}
+
+// settlement is what the gateway pays out for a successful payment.
+type settlement struct {
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+	Fee       int64  `json:"fee"`
+	NetAmount int64  `json:"netAmount"`
+}
+
+// settlementFor applies gateway's matching fee rule to the payment's amount.
+func settlementFor(paymentId, gateway string) settlement {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return settle(lookupPaymentDetails(paymentId), gateway)
+}
+
+// settle applies gateway's matching fee rule to details. Caller must hold
+// cacheMutex.
+func settle(details paymentDetails, gateway string) settlement {
+	fee := matchFeeRule(feeSchedule[gateway], details.Currency).fee(details.Amount, feeRounding)
+	return settlement{
+		Amount:    details.Amount,
+		Currency:  details.Currency,
+		Fee:       fee,
+		NetAmount: details.Amount - fee,
+	}
+}
+
+type feeConfig struct {
+	Rounding string               `json:"rounding"`
+	Gateways map[string][]feeRule `json:"gateways"`
+}
+
+func handleGetFees(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	config := feeConfig{Rounding: feeRounding, Gateways: make(map[string][]feeRule, len(feeSchedule))}
+	for gateway, rules := range feeSchedule {
+		config.Gateways[gateway] = slices.Clone(rules)
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetFees updates the fee schedule and rounding mode, e.g.
+// {"rounding":"half-even","gateways":{"stripe":[{"percent":2.9,"fixed":30},
+// {"percent":1.4,"fixed":25,"currency":"EUR"}]}}. Either field may be left
+// out to keep it; "gateways" replaces the whole schedule, so gateways left
+// out of it charge no fee.
+func handleSetFees(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rounding *string              `json:"rounding"`
+		Gateways map[string][]feeRule `json:"gateways"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.Rounding != nil && !slices.Contains(feeRoundingModes, *req.Rounding) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+			"rounding must be one of "+strings.Join(feeRoundingModes, ", "))
+		return
+	}
+	for gateway, rules := range req.Gateways {
+		if !gatewayNamePattern.MatchString(gateway) {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+				"invalid gateway name "+strconv.Quote(gateway))
+			return
+		}
+		for i, rule := range rules {
+			if rule.Percent < 0 || rule.Percent > 100 || rule.Fixed < 0 {
+				writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+					"fee for "+gateway+" must have percent between 0 and 100 and a non-negative fixed part")
+				return
+			}
+			if rule.Currency != "" && len(rule.Currency) != 3 {
+				writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+					"fee currency for "+gateway+" must be a 3-letter code")
+				return
+			}
+			rules[i].Currency = strings.ToUpper(rule.Currency)
+		}
+	}
+
+	cacheMutex.Lock()
+	if req.Rounding != nil {
+		feeRounding = *req.Rounding
+	}
+	if req.Gateways != nil {
+		feeSchedule = req.Gateways
+	}
+	rounding, gateways := feeRounding, len(feeSchedule)
+	cacheMutex.Unlock()
+
+	logger.Info("Fee schedule updated", "endpoint", "admin", "rounding", rounding, "gateways", gateways)
+
+	handleGetFees(w, r)
+}
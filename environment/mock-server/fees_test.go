@@ -0,0 +1,83 @@
+package main
+
+import "testing"
+
+func TestFeeRounding(t *testing.T) {
+	tests := []struct {
+		name     string
+		percent  float64
+		amount   int64
+		halfUp   int64
+		halfEven int64
+	}{
+		{"half above even", 2.5, 500, 13, 12},                       // 12.5
+		{"half above odd", 2.5, 540, 14, 14},                        // 13.5
+		{"half above zero", 0.5, 100, 1, 0},                         // 0.5
+		{"half above one", 1.5, 100, 2, 2},                          // 1.5
+		{"half not exact in binary, above odd", 1.15, 1000, 12, 12}, // 11.5, as 1.15 is taken as written
+		{"half not exact in binary, above even", 0.45, 1000, 5, 4},  // 4.5
+		{"under half", 0.1, 1250, 1, 1},                             // 1.25
+		{"over half", 3.49, 50, 2, 2},                               // 1.745
+		{"whole", 2.9, 1000, 29, 29},                                // 29
+		{"zero amount", 2.9, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := feeRule{Percent: tt.percent}
+			if got := rule.fee(tt.amount, roundingHalfUp); got != tt.halfUp {
+				t.Errorf("%v%% of %d half-up = %d, want %d", tt.percent, tt.amount, got, tt.halfUp)
+			}
+			if got := rule.fee(tt.amount, roundingHalfEven); got != tt.halfEven {
+				t.Errorf("%v%% of %d half-even = %d, want %d", tt.percent, tt.amount, got, tt.halfEven)
+			}
+		})
+	}
+}
+
+func TestSettleFeeSchedule(t *testing.T) {
+	cacheMutex.Lock()
+	schedule, rounding := feeSchedule, feeRounding
+	feeSchedule = map[string][]feeRule{
+		"stripe": {
+			{Percent: 2.9, Fixed: 30},
+			{Percent: 1.4, Fixed: 25, Currency: "EUR"},
+		},
+		"adyen":  {{Percent: 0, Fixed: 12}},
+		"paypal": {{Percent: 3.49, Fixed: 49, Currency: "USD"}},
+	}
+	feeRounding = roundingHalfUp
+	cacheMutex.Unlock()
+	t.Cleanup(func() {
+		cacheMutex.Lock()
+		feeSchedule, feeRounding = schedule, rounding
+		cacheMutex.Unlock()
+	})
+
+	tests := []struct {
+		name     string
+		gateway  string
+		amount   int64
+		currency string
+		wantFee  int64
+	}{
+		{"percent plus fixed", "stripe", 10000, "USD", 320},       // 290 + 30
+		{"rounded percent plus fixed", "stripe", 1234, "USD", 66}, // 35.786 -> 36, + 30
+		{"currency rule", "stripe", 10000, "EUR", 165},            // 140 + 25
+		{"currency rule any case", "stripe", 10000, "eur", 165},
+		{"fixed only", "adyen", 10000, "USD", 12},
+		{"no rule for currency", "paypal", 10000, "EUR", 0},
+		{"gateway without rules", "klarna", 10000, "USD", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheMutex.RLock()
+			got := settle(paymentDetails{Amount: tt.amount, Currency: tt.currency}, tt.gateway)
+			cacheMutex.RUnlock()
+
+			want := settlement{Amount: tt.amount, Currency: tt.currency, Fee: tt.wantFee, NetAmount: tt.amount - tt.wantFee}
+			if got != want {
+				t.Errorf("settle = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// How payments without a gateway in their ID are assigned one, set via
+// GATEWAY_STRATEGY at startup:
+//
+//	hash         the payment ID's hash picks the gateway, honoring weights
+//	round-robin  successive assignments cycle through the gateway list
+//	random       every assignment picks a gateway at random
+//
+// Only hash is stable per payment ID by itself; the others rely on the
+// gateway cache to keep a payment on the gateway it was first given.
+const (
+	strategyHash       = "hash"
+	strategyRoundRobin = "round-robin"
+	strategyRandom     = "random"
+)
+
+var gatewayStrategies = []string{strategyHash, strategyRoundRobin, strategyRandom}
+
+var gatewayStrategy = strategyHash
+
+// Assignments made so far under round-robin. Atomic, so determineGateway only
+// needs cacheMutex for reading.
+var roundRobinNext atomic.Uint64
+
+// Relative share of hash-assigned payments per gateway (guarded by cacheMutex,
+// adjustable via /admin/gateways/weights). Gateways without an entry weigh 1,
+// so with no weights configured the split is even.
+var gatewayWeights = map[string]int{}
+
+// Largest weight a gateway may have, so the weights' sum can't overflow
+const maxGatewayWeight = 1_000_000
+
+// gatewayWeight returns a gateway's effective weight. Caller must hold cacheMutex.
+func gatewayWeight(name string) int {
+	if weight, ok := gatewayWeights[name]; ok {
+		return weight
+	}
+	return 1
+}
+
+// weightedGateway maps a payment hash onto the registered gateways in
+// proportion to their weights, so a given hash always lands on the same
+// gateway for a given configuration. Caller must hold cacheMutex.
+func weightedGateway(hash [md5.Size]byte) string {
+	total := 0
+	for _, name := range gateways {
+		total += gatewayWeight(name)
+	}
+	if total == 0 {
+		// Every gateway weighted 0; fall back to an even split rather than failing
+		return gateways[hashIndex(hash, uint64(len(gateways)))]
+	}
+
+	point := int(hashIndex(hash, uint64(total)))
+	for _, name := range gateways {
+		point -= gatewayWeight(name)
+		if point < 0 {
+			return name
+		}
+	}
+	return gateways[len(gateways)-1]
+}
+
+// hashIndex derives a uniformly distributed index in [0,n) from a hash. A
+// plain modulo of the first 8 bytes would favor low indexes whenever n doesn't
+// divide 2^64, so values from the incomplete top range are rejected and the
+// hash is re-hashed until one falls in range (for realistic n that's about
+// never, but it keeps the split exact).
+func hashIndex(hash [md5.Size]byte, n uint64) uint64 {
+	excess := (math.MaxUint64%n + 1) % n // 2^64 mod n
+	for {
+		if v := binary.BigEndian.Uint64(hash[:8]); v <= math.MaxUint64-excess {
+			return v % n
+		}
+		hash = md5.Sum(hash[:])
+	}
+}
+
+// Gateway per customer ID for X-Customer-Id lookups, so all of a customer's
+// payments share a gateway. Shares the gateway cache limit, is guarded by
+// gatewayCacheMutex and isn't persisted across restarts.
+var customerGatewayCache = newLRUCache[string](0)
+
+// customerGateway returns the customer's gateway, assigning one by hashing the
+// customer ID on first use. The assignment is remembered only when cache is
+// set, and one pointing at a since-removed gateway is replaced.
+func customerGateway(customerId string, cache bool) string {
+	gatewayCacheMutex.Lock()
+	gateway, exists := customerGatewayCache.Get(customerId)
+	gatewayCacheMutex.Unlock()
+	if exists && slices.Contains(currentGateways(), gateway) {
+		return gateway
+	}
+
+	gateway = determineGateway(customerId)
+	if cache {
+		gatewayCacheMutex.Lock()
+		customerGatewayCache.Put(customerId, gateway)
+		gatewayCacheMutex.Unlock()
+	}
+	return gateway
+}
+
+// Gateway names end up in cache keys ("gateway:ids") and URL paths, so keep
+// them to a conservative alphabet.
+var gatewayNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// parseGatewayList parses a comma-separated gateway list such as GATEWAYS.
+// Names are trimmed and lowercased; the list must be non-empty, without
+// duplicates, and every name must match gatewayNamePattern.
+func parseGatewayList(value string) ([]string, error) {
+	var names []string
+	for _, part := range strings.Split(value, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		if !gatewayNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid gateway name %q", part)
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("duplicate gateway %q", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// currentGateways returns a copy of the registered gateways.
+func currentGateways() []string {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return slices.Clone(gateways)
+}
+
+type gatewayInfo struct {
+	Name           string `json:"name"`
+	CachedPayments int    `json:"cachedPayments"`
+}
+
+// handleGetGateways lists the registered gateways in assignment order with how
+// many live gateway cache entries of the ?tenant= tenant map to each. Entries
+// pointing at gateways that have since been removed are counted under
+// "unregistered".
+func handleGetGateways(w http.ResponseWriter, r *http.Request) {
+	caches, ok := adminTenant(w, r, false)
+	if !ok {
+		return
+	}
+
+	cacheMutex.RLock()
+	counts := cachedGatewayCounts(caches.gateway, clockNow())
+	list := make([]gatewayInfo, 0, len(gateways))
+	for _, name := range gateways {
+		list = append(list, gatewayInfo{Name: name, CachedPayments: counts[name]})
+		delete(counts, name)
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"gateways":     list,
+		"unregistered": counts,
+	})
+}
+
+// cachedGatewayCounts counts live entries of a gateway cache per gateway.
+// Caller must hold cacheMutex.
+func cachedGatewayCounts(cache *lruCache[gatewayEntry], now time.Time) map[string]int {
+	counts := make(map[string]int)
+	gatewayCacheMutex.RLock()
+	defer gatewayCacheMutex.RUnlock()
+	cache.Range(func(_ string, entry gatewayEntry) {
+		if !entry.expired(now, gatewayCacheTTL) {
+			counts[entry.Gateway]++
+		}
+	})
+	return counts
+}
+
+type gatewayShare struct {
+	Name            string  `json:"name"`
+	Count           int     `json:"count"`
+	Percent         float64 `json:"percent"`
+	ExpectedPercent float64 `json:"expectedPercent"`
+}
+
+// handleGetGatewayDistribution reports how the ?tenant= tenant's live gateway
+// cache entries are split across the registered gateways, next to the split
+// the current strategy and weights aim for. Percentages are of all live
+// entries, so entries on removed gateways ("unregistered") make them sum
+// below 100.
+func handleGetGatewayDistribution(w http.ResponseWriter, r *http.Request) {
+	caches, ok := adminTenant(w, r, false)
+	if !ok {
+		return
+	}
+
+	cacheMutex.RLock()
+	counts := cachedGatewayCounts(caches.gateway, clockNow())
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	// Weights only steer hash assignment; the other strategies split evenly,
+	// as does hash when every weight is 0 (see weightedGateway)
+	weights := make([]int, len(gateways))
+	weightTotal := 0
+	if gatewayStrategy == strategyHash {
+		for i, name := range gateways {
+			weights[i] = gatewayWeight(name)
+			weightTotal += weights[i]
+		}
+	}
+	if weightTotal == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		weightTotal = len(weights)
+	}
+	list := make([]gatewayShare, 0, len(gateways))
+	for i, name := range gateways {
+		share := gatewayShare{Name: name, Count: counts[name], ExpectedPercent: roundPercent(weights[i], weightTotal)}
+		if total > 0 {
+			share.Percent = roundPercent(share.Count, total)
+		}
+		list = append(list, share)
+		delete(counts, name)
+	}
+	strategy := gatewayStrategy
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"strategy":     strategy,
+		"total":        total,
+		"gateways":     list,
+		"unregistered": counts,
+	})
+}
+
+// roundPercent returns part/total as a percentage with two decimals.
+func roundPercent(part, total int) float64 {
+	return math.Round(float64(part)*10000/float64(total)) / 100
+}
+
+// handleAddGateway registers a gateway at runtime, e.g. {"name":"klarna"}.
+// New payments can be assigned to it immediately.
+func handleAddGateway(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	if !gatewayNamePattern.MatchString(name) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "name must be non-empty and contain only a-z, 0-9, '_' or '-'")
+		return
+	}
+
+	cacheMutex.Lock()
+	if slices.Contains(gateways, name) {
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusConflict, codeConflict, "Conflict", "Gateway '"+name+"' already exists")
+		return
+	}
+	gateways = append(gateways, name)
+	cacheMutex.Unlock()
+
+	logger.Info("Gateway added", "endpoint", "admin", "gateway", name)
+
+	handleGetGateways(w, r)
+}
+
+// handleRemoveGateway unregisters a gateway. Payments already cached on it
+// keep their assignment until they expire or are evicted; the last gateway
+// can't be removed since every payment needs one.
+func handleRemoveGateway(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	cacheMutex.Lock()
+	i := slices.Index(gateways, name)
+	if i < 0 {
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "Gateway '"+name+"' not found")
+		return
+	}
+	if len(gateways) == 1 {
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusConflict, codeConflict, "Conflict", "Cannot remove the last gateway")
+		return
+	}
+	gateways = slices.Delete(gateways, i, i+1)
+	cached := 0
+	gatewayCacheMutex.RLock()
+	for _, caches := range append([]*tenantCaches{defaultCaches}, slices.Collect(maps.Values(namedTenants()))...) {
+		caches.gateway.Range(func(_ string, entry gatewayEntry) {
+			if entry.Gateway == name {
+				cached++
+			}
+		})
+	}
+	gatewayCacheMutex.RUnlock()
+	cacheMutex.Unlock()
+
+	if cached > 0 {
+		logger.Warn("Gateway removed while payments are still cached on it", "endpoint", "admin", "gateway", name, "cachedPayments", cached)
+	} else {
+		logger.Info("Gateway removed", "endpoint", "admin", "gateway", name)
+	}
+
+	handleGetGateways(w, r)
+}
+
+func handleGetGatewayWeights(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	weights := make(map[string]int, len(gateways))
+	for _, name := range gateways {
+		weights[name] = gatewayWeight(name)
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(weights)
+}
+
+// handleSetGatewayWeights replaces the weights, e.g.
+// {"stripe":70,"adyen":20,"paypal":10}. Registered gateways left out weigh 1;
+// a weight of 0 means payments are only routed there by name. Changing weights
+// doesn't move payments that are already cached.
+func handleSetGatewayWeights(w http.ResponseWriter, r *http.Request) {
+	var req map[string]int
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	known := currentGateways()
+	for name, weight := range req {
+		if !slices.Contains(known, name) {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Unknown gateway '"+name+"'")
+			return
+		}
+		if weight < 0 || weight > maxGatewayWeight {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+				"Weight for gateway '"+name+"' must be between 0 and "+strconv.Itoa(maxGatewayWeight))
+			return
+		}
+	}
+
+	cacheMutex.Lock()
+	gatewayWeights = req
+	cacheMutex.Unlock()
+
+	logger.Info("Gateway weights updated", "endpoint", "admin", "weights", req)
+
+	handleGetGatewayWeights(w, r)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"maps"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// withGatewayWeights sets the hash weights for the test, restoring the
+// previous ones afterwards.
+func withGatewayWeights(t *testing.T, weights map[string]int) {
+	t.Helper()
+	cacheMutex.Lock()
+	previous := gatewayWeights
+	gatewayWeights = maps.Clone(weights)
+	cacheMutex.Unlock()
+
+	t.Cleanup(func() {
+		cacheMutex.Lock()
+		gatewayWeights = previous
+		cacheMutex.Unlock()
+	})
+}
+
+func TestWeightedGatewaySplit(t *testing.T) {
+	withGatewayWeights(t, map[string]int{"stripe": 70, "adyen": 20, "paypal": 10})
+
+	const n = 100_000
+	counts := make(map[string]int)
+	for i := range n {
+		counts[determineGateway("pay_"+strconv.Itoa(i))]++
+	}
+
+	for gateway, want := range map[string]float64{"stripe": 0.7, "adyen": 0.2, "paypal": 0.1} {
+		if got := float64(counts[gateway]) / n; math.Abs(got-want) > 0.01 {
+			t.Errorf("%s got %.3f of %d payments, want %.2f ± 0.01", gateway, got, n, want)
+		}
+	}
+}
+
+func TestHashIndexUniform(t *testing.T) {
+	const ids = 100_000
+	for _, n := range []uint64{2, 3, 5, 7} {
+		t.Run(strconv.FormatUint(n, 10), func(t *testing.T) {
+			counts := make([]int, n)
+			for i := range ids {
+				counts[hashIndex(md5.Sum([]byte("pay_"+strconv.Itoa(i))), n)]++
+			}
+
+			want := 1 / float64(n)
+			for index, count := range counts {
+				if got := float64(count) / ids; math.Abs(got-want) > 0.01 {
+					t.Errorf("index %d got %.4f of %d IDs, want %.4f ± 0.01", index, got, ids, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHashIndexRehashesOutOfRange(t *testing.T) {
+	// 2^64 mod 3 is 1, so only the top value MaxUint64 is out of range
+	var top [md5.Size]byte
+	binary.BigEndian.PutUint64(top[:8], math.MaxUint64)
+	rehashed := md5.Sum(top[:])
+	if got, want := hashIndex(top, 3), binary.BigEndian.Uint64(rehashed[:8])%3; got != want {
+		t.Errorf("hashIndex(MaxUint64, 3) = %d, want %d from the re-hash", got, want)
+	}
+
+	// Just below it is kept as is
+	var below [md5.Size]byte
+	binary.BigEndian.PutUint64(below[:8], math.MaxUint64-1)
+	if got, want := hashIndex(below, 3), uint64((math.MaxUint64-1)%3); got != want {
+		t.Errorf("hashIndex(MaxUint64-1, 3) = %d, want %d", got, want)
+	}
+
+	// A power of two divides 2^64, so nothing is rejected
+	if got, want := hashIndex(top, 4), uint64(math.MaxUint64%4); got != want {
+		t.Errorf("hashIndex(MaxUint64, 4) = %d, want %d", got, want)
+	}
+}
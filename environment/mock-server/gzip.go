@@ -0,0 +1,131 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// Responses smaller than this aren't worth compressing
+const gzipMinBytes = 1024
+
+// withGzip compresses responses for clients that send Accept-Encoding: gzip.
+// Output is buffered until gzipMinBytes so small bodies go out unchanged, and
+// handlers that set their own Content-Encoding (promhttp) are left alone.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding value allows gzip.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the status and the first gzipMinBytes of the
+// body, then commits to either a gzip or a plain response.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.decided {
+		return
+	}
+	g.status = status
+	// Bodyless statuses have nothing to compress
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		g.commit(false)
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !g.decided {
+		g.buf = append(g.buf, p...)
+		if len(g.buf) < gzipMinBytes {
+			return len(p), nil
+		}
+		if err := g.commit(g.Header().Get("Content-Encoding") == ""); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(p)
+	}
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been buffered so far. A stream flushed before reaching
+// the threshold (e.g. server-sent events) stays uncompressed.
+func (g *gzipResponseWriter) Flush() {
+	if !g.decided {
+		g.commit(false)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to hijack).
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// commit writes the held status and buffered body, compressed or not.
+func (g *gzipResponseWriter) commit(compress bool) error {
+	g.decided = true
+	if compress {
+		// Sniff before compressing, or net/http would sniff the gzip bytes
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(g.buf))
+		}
+		g.Header().Set("Content-Encoding", "gzip")
+		g.Header().Del("Content-Length")
+		g.ResponseWriter.WriteHeader(g.status)
+		g.gz = gzip.NewWriter(g.ResponseWriter)
+		_, err := g.gz.Write(g.buf)
+		g.buf = nil
+		return err
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	_, err := g.ResponseWriter.Write(g.buf)
+	g.buf = nil
+	return err
+}
+
+// finish flushes a response that never reached the threshold and closes the
+// gzip stream. Hijacked connections have nothing left to write.
+func (g *gzipResponseWriter) finish() {
+	if !g.decided {
+		if len(g.buf) == 0 && g.status == http.StatusOK {
+			// Nothing written at all; let net/http send its default response
+			return
+		}
+		g.commit(false)
+		return
+	}
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
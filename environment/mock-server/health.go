@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Server lifecycle as reported by the readiness probe.
+const (
+	phaseStarting int32 = iota // restoring caches
+	phaseReady
+	phaseDraining // shutdown signal received
+)
+
+var (
+	serverPhase atomic.Int32 // starts at phaseStarting
+
+	// How long readiness reports "draining" before the listener closes, so an
+	// orchestrator can stop routing traffic first (overridable via
+	// SHUTDOWN_DRAIN_DELAY). 0 shuts down immediately.
+	shutdownDrainDelay time.Duration
+)
+
+var phaseNames = map[int32]string{
+	phaseStarting: "starting",
+	phaseReady:    "ready",
+	phaseDraining: "draining",
+}
+
+// handleLiveness reports that the process is up and serving HTTP.
+func handleLiveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadiness reports whether business traffic should be routed here:
+// 200 once caches are restored, 503 while starting, in maintenance mode or
+// draining for shutdown.
+func handleReadiness(w http.ResponseWriter, _ *http.Request) {
+	status := phaseNames[serverPhase.Load()]
+	if status == "ready" && maintenanceMode.Load() {
+		status = "maintenance"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Window in which a repeat of a successful notify (same idbCacheKey) is
+// rejected with 409 instead of answered from the cache (guarded by
+// cacheMutex, adjustable via IDB_DEDUP_WINDOW or /admin/idb-dedup). 0
+// disables the check.
+var idbDedupWindow time.Duration
+
+// When each of the default tenant's batches last went through, by
+// idbCacheKey (see tenantCaches). Shares the idb cache limit and lock
+// (idbCacheMutex). Not persisted across restarts.
+var idbNotifiedAt = newLRUCache[time.Time](0)
+
+func currentIdbDedupWindow() time.Duration {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return idbDedupWindow
+}
+
+// idbDuplicateWait reports how much longer cacheKey counts as a duplicate in
+// notifiedAt (the tenant's), or 0 when it may be notified now.
+func idbDuplicateWait(notifiedAt *lruCache[time.Time], cacheKey string, now time.Time) time.Duration {
+	window := currentIdbDedupWindow()
+	if window <= 0 {
+		return 0
+	}
+
+	idbCacheMutex.RLock()
+	last, seen := notifiedAt.Peek(cacheKey)
+	idbCacheMutex.RUnlock()
+	if !seen {
+		return 0
+	}
+	return max(window-now.Sub(last), 0)
+}
+
+func writeDuplicateNotification(w http.ResponseWriter, wait time.Duration) {
+	// Round up so a client honoring Retry-After lands outside the window
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((wait+time.Second-1)/time.Second), 10))
+	writeProblemWith(w, http.StatusConflict, codeDuplicateNotification, "Duplicate notification",
+		"The same payment set was already notified within the dedup window",
+		map[string]any{"retryAfterMs": wait.Milliseconds()})
+}
+
+func handleGetIdbDedup(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"windowMs": currentIdbDedupWindow().Milliseconds()})
+}
+
+// handleSetIdbDedup sets the dedup window, e.g. {"windowMs":30000}. 0
+// disables it.
+func handleSetIdbDedup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		WindowMs int64 `json:"windowMs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.WindowMs < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "windowMs must not be negative")
+		return
+	}
+
+	cacheMutex.Lock()
+	idbDedupWindow = time.Duration(req.WindowMs) * time.Millisecond
+	cacheMutex.Unlock()
+
+	logger.Info("IDB dedup window updated", "endpoint", "admin", "windowMs", req.WindowMs)
+
+	handleGetIdbDedup(w, r)
+}
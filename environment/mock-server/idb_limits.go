@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Limits on a single IDB notify (guarded by cacheMutex, adjustable via
+// IDB_MAX_BODY_BYTES / IDB_MAX_BATCH_SIZE or /admin/idb-limits). 0 disables
+// the batch size check.
+var (
+	idbMaxBodyBytes int64 = 1 << 20
+	idbMaxBatchSize       = 1000
+)
+
+type idbLimits struct {
+	MaxBodyBytes int64 `json:"maxBodyBytes"`
+	MaxBatchSize int   `json:"maxBatchSize"`
+}
+
+func currentIdbLimits() idbLimits {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return idbLimits{MaxBodyBytes: idbMaxBodyBytes, MaxBatchSize: idbMaxBatchSize}
+}
+
+func handleGetIdbLimits(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentIdbLimits())
+}
+
+// handleSetIdbLimits updates the notify limits, e.g.
+// {"maxBodyBytes":65536,"maxBatchSize":100}. Omitted fields are left unchanged.
+func handleSetIdbLimits(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MaxBodyBytes *int64 `json:"maxBodyBytes"`
+		MaxBatchSize *int   `json:"maxBatchSize"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.MaxBodyBytes != nil && *req.MaxBodyBytes < 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "maxBodyBytes must be positive")
+		return
+	}
+	if req.MaxBatchSize != nil && *req.MaxBatchSize < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "maxBatchSize must not be negative")
+		return
+	}
+
+	cacheMutex.Lock()
+	if req.MaxBodyBytes != nil {
+		idbMaxBodyBytes = *req.MaxBodyBytes
+	}
+	if req.MaxBatchSize != nil {
+		idbMaxBatchSize = *req.MaxBatchSize
+	}
+	cacheMutex.Unlock()
+
+	logger.Info("IDB limits updated", "endpoint", "admin", "maxBodyBytes", req.MaxBodyBytes, "maxBatchSize", req.MaxBatchSize)
+
+	handleGetIdbLimits(w, r)
+}
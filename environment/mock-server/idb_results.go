@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// Per-payment results for a batch notify
+const (
+	idbResultOk     = "ok"
+	idbResultFailed = "failed"
+)
+
+type idbResult struct {
+	PaymentId string `json:"paymentId"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Code      string `json:"code,omitempty"`
+}
+
+// Per-payment failure rules inside an otherwise successful notify (guarded by
+// cacheMutex, adjustable via /admin/idb-item-failures): each payment fails
+// with probability idbItemFailureRate, and listed payments always fail.
+var (
+	idbItemFailureRate float64
+	idbFailingIds      = make(map[string]struct{})
+)
+
+// idbItemResults decides each payment's result. Forced successes skip the
+// failure rules entirely.
+func idbItemResults(paymentIds []string, forceSuccess bool) []idbResult {
+	cacheMutex.RLock()
+	results := make([]idbResult, 0, len(paymentIds))
+	for _, paymentId := range paymentIds {
+		_, listed := idbFailingIds[paymentId]
+		if !forceSuccess && (listed || rollFailure(idbItemFailureRate)) {
+			results = append(results, idbResult{PaymentId: paymentId, Status: idbResultFailed, Error: internalErrorTitles[codeIdbInternal], Code: codeIdbInternal})
+		} else {
+			results = append(results, idbResult{PaymentId: paymentId, Status: idbResultOk})
+		}
+	}
+	cacheMutex.RUnlock()
+	return results
+}
+
+// succeededIds returns the payment IDs whose result is ok.
+func succeededIds(results []idbResult) []string {
+	ids := make([]string, 0, len(results))
+	for _, result := range results {
+		if result.Status == idbResultOk {
+			ids = append(ids, result.PaymentId)
+		}
+	}
+	return ids
+}
+
+// idbNotifyResponse builds the notify body. The HTTP status is 200 either
+// way; "status" is "partial" when any payment failed.
+func idbNotifyResponse(gateway string, results []idbResult) map[string]any {
+	status, message := "ok", "Payments notified successfully"
+	failed := len(results) - len(succeededIds(results))
+	if failed > 0 {
+		status, message = "partial", "Some payments failed to notify"
+	}
+	return map[string]any{
+		"status":    status,
+		"message":   message,
+		"gateway":   gateway,
+		"count":     len(results),
+		"failed":    failed,
+		"results":   results,
+		"timestamp": formatTimestamp(clockNow()),
+	}
+}
+
+type idbItemFailureConfig struct {
+	Rate       float64  `json:"rate"`
+	PaymentIds []string `json:"paymentIds"`
+}
+
+func handleGetIdbItemFailures(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	config := idbItemFailureConfig{Rate: idbItemFailureRate, PaymentIds: make([]string, 0, len(idbFailingIds))}
+	for paymentId := range idbFailingIds {
+		config.PaymentIds = append(config.PaymentIds, paymentId)
+	}
+	cacheMutex.RUnlock()
+	slices.Sort(config.PaymentIds)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetIdbItemFailures configures per-payment failures, e.g.
+// {"rate":0.1,"paymentIds":["pay_bad"]}. Omitted fields are left unchanged;
+// paymentIds replaces the whole list.
+func handleSetIdbItemFailures(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Rate       *float64 `json:"rate"`
+		PaymentIds []string `json:"paymentIds"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.Rate != nil && (*req.Rate < 0 || *req.Rate > 1) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "rate must be between 0 and 1")
+		return
+	}
+
+	cacheMutex.Lock()
+	if req.Rate != nil {
+		idbItemFailureRate = *req.Rate
+	}
+	if req.PaymentIds != nil {
+		idbFailingIds = make(map[string]struct{}, len(req.PaymentIds))
+		for _, paymentId := range req.PaymentIds {
+			idbFailingIds[paymentId] = struct{}{}
+		}
+	}
+	cacheMutex.Unlock()
+
+	logger.Info("IDB item failures updated", "endpoint", "admin", "rate", req.Rate, "paymentIds", req.PaymentIds)
+
+	handleGetIdbItemFailures(w, r)
+}
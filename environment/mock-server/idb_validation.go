@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// fieldError is one failed check on a request body field. Field is a path
+// into the body, e.g. "gatewayName" or "paymentIds[2]".
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// validateIdbNotify checks a decoded notify body: gatewayName must name a
+// registered gateway (exactly, so a wrongly cased name is caught), and
+// paymentIds must be a non-empty array of IDs matching the payment ID
+// pattern. It returns every problem found rather than stopping at the first.
+func validateIdbNotify(gateway string, paymentIds []string) []fieldError {
+	var errs []fieldError
+
+	registered := currentGateways()
+	switch {
+	case gateway == "":
+		errs = append(errs, fieldError{"gatewayName", "must not be empty"})
+	case !slices.Contains(registered, gateway):
+		message := "unknown gateway " + strconv.Quote(gateway) + ", must be one of " + strings.Join(registered, ", ")
+		if lower := strings.ToLower(gateway); lower != gateway && slices.Contains(registered, lower) {
+			message += " (gateway names are lowercase, did you mean " + strconv.Quote(lower) + "?)"
+		}
+		errs = append(errs, fieldError{"gatewayName", message})
+	}
+
+	if len(paymentIds) == 0 {
+		errs = append(errs, fieldError{"paymentIds", "must be a non-empty array"})
+	}
+	pattern := currentPaymentIdPattern()
+	for i, paymentId := range paymentIds {
+		if !pattern.MatchString(paymentId) {
+			errs = append(errs, fieldError{
+				"paymentIds[" + strconv.Itoa(i) + "]",
+				"invalid payment ID " + strconv.Quote(paymentId) + ", must match " + pattern.String(),
+			})
+		}
+	}
+	return errs
+}
+
+// writeValidationProblem sends a 422 listing the failed fields under
+// "errors".
+func writeValidationProblem(w http.ResponseWriter, errs []fieldError) {
+	writeProblemWith(w, http.StatusUnprocessableEntity, codeValidationFailed, "Unprocessable Entity",
+		"Request body failed validation", map[string]any{"errors": errs})
+}
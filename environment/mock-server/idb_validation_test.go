@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateIdbNotify(t *testing.T) {
+	tests := []struct {
+		name       string
+		gateway    string
+		paymentIds []string
+		want       []fieldError // Message is matched as a substring
+	}{
+		{
+			name:       "valid",
+			gateway:    "adyen",
+			paymentIds: []string{"pay_1", "pay_2"},
+		},
+		{
+			name:       "empty gateway",
+			gateway:    "",
+			paymentIds: []string{"pay_1"},
+			want:       []fieldError{{"gatewayName", "must not be empty"}},
+		},
+		{
+			name:       "unknown gateway",
+			gateway:    "worldpay",
+			paymentIds: []string{"pay_1"},
+			want:       []fieldError{{"gatewayName", `unknown gateway "worldpay", must be one of stripe, adyen, paypal`}},
+		},
+		{
+			name:       "wrongly cased gateway",
+			gateway:    "Adyen",
+			paymentIds: []string{"pay_1"},
+			want:       []fieldError{{"gatewayName", `did you mean "adyen"?`}},
+		},
+		{
+			name:       "null paymentIds",
+			gateway:    "adyen",
+			paymentIds: nil,
+			want:       []fieldError{{"paymentIds", "must be a non-empty array"}},
+		},
+		{
+			name:       "empty paymentIds",
+			gateway:    "adyen",
+			paymentIds: []string{},
+			want:       []fieldError{{"paymentIds", "must be a non-empty array"}},
+		},
+		{
+			name:       "non-matching ID",
+			gateway:    "adyen",
+			paymentIds: []string{"pay_1", "pay 2", "pay_3"},
+			want:       []fieldError{{"paymentIds[1]", `invalid payment ID "pay 2"`}},
+		},
+		{
+			name:       "several errors",
+			gateway:    "STRIPE",
+			paymentIds: []string{"", "pay_1", "pay/2"},
+			want: []fieldError{
+				{"gatewayName", `did you mean "stripe"?`},
+				{"paymentIds[0]", `invalid payment ID ""`},
+				{"paymentIds[2]", `invalid payment ID "pay/2"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := validateIdbNotify(tt.gateway, tt.paymentIds)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d errors %v, want %d %v", len(got), got, len(tt.want), tt.want)
+			}
+			for i, want := range tt.want {
+				if got[i].Field != want.Field || !strings.Contains(got[i].Message, want.Message) {
+					t.Errorf("error %d = %s: %q, want %s containing %q", i, got[i].Field, got[i].Message, want.Field, want.Message)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+// Header clients use to make an IDB notify safely retryable, Stripe-style.
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentResponse is the first successful response sent for an
+// Idempotency-Key, together with the idbCacheKey of the request that produced
+// it.
+type idempotentResponse struct {
+	Fingerprint string
+	Body        []byte
+}
+
+// The default tenant's responses by Idempotency-Key (see tenantCaches).
+// Shares the idb cache limit and lock (idbCacheMutex). Not persisted across
+// restarts.
+var idbIdempotencyKeys = newLRUCache[idempotentResponse](0)
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Build identity, injected at build time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.gitCommit=$(git rev-parse --short HEAD)"
+//
+// When gitCommit isn't injected, the VCS revision Go stamps into binaries
+// built from a checkout is used instead ("-dirty" if it had local changes).
+var (
+	version   = "dev"
+	gitCommit = ""
+)
+
+// Set once at startup and only read afterwards, so /admin/info needs no lock.
+var startTime = time.Now()
+
+func init() {
+	if gitCommit != "" {
+		return
+	}
+	gitCommit = "unknown"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	dirty := false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			gitCommit = setting.Value
+		case "vcs.modified":
+			dirty = setting.Value == "true"
+		}
+	}
+	if dirty && gitCommit != "unknown" {
+		gitCommit += "-dirty"
+	}
+}
+
+// handleAdminInfo reports which build this is and how long it has been up.
+func handleAdminInfo(w http.ResponseWriter, _ *http.Request) {
+	uptime := time.Since(startTime)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"version":         version,
+		"gitCommit":       gitCommit,
+		"goVersion":       runtime.Version(),
+		"startTime":       startTime.UTC().Format(time.RFC3339),
+		"uptime":          uptime.Round(time.Second).String(),
+		"uptimeMs":        uptime.Milliseconds(),
+		"rngSeed":         rngSeed, // null when unseeded
+		"deterministic":   deterministicMode,
+		"gatewayStrategy": gatewayStrategy,
+		"timestampFormat": timestampFormat,
+	})
+}
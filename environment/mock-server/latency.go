@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Latency distributions supported by latencySpec.
+const (
+	distFixed   = "fixed"
+	distUniform = "uniform"
+	distNormal  = "normal"
+)
+
+// latencySpec describes how long an endpoint sleeps before responding.
+// All values are in milliseconds; which fields apply depends on Distribution:
+//   - fixed:   ms
+//   - uniform: min, max
+//   - normal:  mean, stddev
+//
+// Independently of the distribution, a SpikeRate fraction of requests sleep
+// an extra SpikeMs on top, to reproduce tail latency.
+type latencySpec struct {
+	Distribution string  `json:"distribution"`
+	Ms           float64 `json:"ms,omitempty"`
+	Min          float64 `json:"min,omitempty"`
+	Max          float64 `json:"max,omitempty"`
+	Mean         float64 `json:"mean,omitempty"`
+	Stddev       float64 `json:"stddev,omitempty"`
+	SpikeRate    float64 `json:"spikeRate,omitempty"`
+	SpikeMs      float64 `json:"spikeMs,omitempty"`
+}
+
+// UnmarshalJSON accepts either a full spec object or a bare number, which is
+// shorthand for a fixed latency (keeps {"es":10,"idb":100} working).
+func (l *latencySpec) UnmarshalJSON(data []byte) error {
+	var ms float64
+	if err := json.Unmarshal(data, &ms); err == nil {
+		*l = latencySpec{Distribution: distFixed, Ms: ms}
+		return nil
+	}
+
+	type plain latencySpec
+	var spec plain
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+	if spec.Distribution == "" {
+		spec.Distribution = distFixed
+	}
+	*l = latencySpec(spec)
+	return nil
+}
+
+func (l latencySpec) validate() error {
+	if l.SpikeRate < 0 || l.SpikeRate > 1 {
+		return fmt.Errorf("spikeRate must be between 0 and 1")
+	}
+	if l.SpikeMs < 0 {
+		return fmt.Errorf("spikeMs must not be negative")
+	}
+	switch l.Distribution {
+	case distFixed:
+		if l.Ms < 0 {
+			return fmt.Errorf("ms must not be negative")
+		}
+	case distUniform:
+		if l.Min < 0 || l.Max < l.Min {
+			return fmt.Errorf("uniform requires 0 <= min <= max")
+		}
+	case distNormal:
+		if l.Mean < 0 || l.Stddev < 0 {
+			return fmt.Errorf("normal requires non-negative mean and stddev")
+		}
+	default:
+		return fmt.Errorf("unknown distribution %q (expected fixed, uniform or normal)", l.Distribution)
+	}
+	return nil
+}
+
+// draw samples a delay from the distribution, clamping negative draws to
+// zero, and adds a spike when one is rolled (never in deterministic mode).
+func (l latencySpec) draw() time.Duration {
+	var ms float64
+	switch l.Distribution {
+	case distUniform:
+		ms = l.Min + randFloat64()*(l.Max-l.Min)
+	case distNormal:
+		ms = l.Mean + randNormFloat64()*l.Stddev
+	default:
+		ms = l.Ms
+	}
+	if ms < 0 {
+		ms = 0
+	}
+	if rollFailure(l.SpikeRate) {
+		ms += l.SpikeMs
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// Per-endpoint latency (guarded by cacheMutex, adjustable via /admin/latency)
+var latencyConfig = map[string]latencySpec{
+	"es":  {Distribution: distFixed, Ms: 0},
+	"idb": {Distribution: distFixed, Ms: 50},
+	"pgi": {Distribution: distFixed, Ms: 30},
+}
+
+// simulateLatency sleeps for a delay drawn from the configured distribution:
+// the request's region's (X-Region) when it has one, else the endpoint's.
+func simulateLatency(r *http.Request, endpoint string) {
+	cacheMutex.RLock()
+	spec, ok := regionLatency[requestRegion(r)]
+	if !ok {
+		spec = latencyConfig[endpoint]
+	}
+	cacheMutex.RUnlock()
+
+	if d := spec.draw(); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func currentLatency() map[string]latencySpec {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+
+	result := make(map[string]latencySpec, len(latencyConfig))
+	for endpoint, spec := range latencyConfig {
+		result[endpoint] = spec
+	}
+	return result
+}
+
+func handleGetLatency(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentLatency())
+}
+
+// handleSetLatency updates the endpoints present in the body, e.g.
+// {"es":10,"idb":{"distribution":"uniform","min":50,"max":150},
+// "pgi":{"distribution":"normal","mean":200,"stddev":50,"spikeRate":0.01,"spikeMs":5000}}.
+func handleSetLatency(w http.ResponseWriter, r *http.Request) {
+	var req map[string]latencySpec
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	for endpoint, spec := range req {
+		if _, ok := latencyConfig[endpoint]; !ok {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Unknown endpoint '"+endpoint+"' (expected es, idb or pgi)")
+			return
+		}
+		if err := spec.validate(); err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid latency for '"+endpoint+"': "+err.Error())
+			return
+		}
+	}
+
+	cacheMutex.Lock()
+	for endpoint, spec := range req {
+		latencyConfig[endpoint] = spec
+	}
+	cacheMutex.Unlock()
+
+	logger.Info("Latency updated", "endpoint", "admin", "latency", req)
+
+	handleGetLatency(w, r)
+}
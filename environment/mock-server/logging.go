@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevel gates structured logging; per-request chatter is logged at debug
+// so it is suppressed at the default info level. Set via LOG_LEVEL.
+var logLevel = new(slog.LevelVar)
+
+// logger emits one JSON object per event with level, msg and ts plus whatever
+// request fields (requestId, endpoint, paymentId, gateway) the caller
+// attaches. The startup banner stays on the standard log package.
+var logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+	Level:       logLevel,
+	ReplaceAttr: formatLogAttr,
+}))
+
+func formatLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		return slog.String("ts", a.Value.Time().UTC().Format(time.RFC3339Nano))
+	case slog.LevelKey:
+		return slog.String(slog.LevelKey, strings.ToLower(a.Value.String()))
+	}
+	return a
+}
+
+// requestLogger returns a logger tagged with the request's correlation ID,
+// trace ID, the endpoint and, when known, the payment and gateway. Empty
+// fields are omitted. The payment and gateway are also recorded on the
+// request's span.
+func requestLogger(r *http.Request, endpoint, paymentId, gateway string) *slog.Logger {
+	args := []any{"endpoint", endpoint}
+	if id := requestId(r); id != "" {
+		args = append(args, "requestId", id)
+	}
+	if tenant := tenantId(r); tenant != "" {
+		args = append(args, "tenant", tenant)
+	}
+	if trace := requestTrace(r); trace != nil {
+		trace.annotate(paymentId, gateway)
+		args = append(args, "traceId", trace.TraceId)
+	}
+	if paymentId != "" {
+		args = append(args, "paymentId", paymentId)
+	}
+	if gateway != "" {
+		args = append(args, "gateway", gateway)
+	}
+	return logger.With(args...)
+}
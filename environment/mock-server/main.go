@@ -1,122 +1,518 @@
 package main
 
 import (
+	"context"
 	"crypto/md5"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"log"
-	"math/rand/v2"
+	"log/slog"
+	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var (
 	// In-memory cache for idempotent SUCCESS responses only
-	gatewayCache  = make(map[string]string) // paymentId -> gateway (only successful lookups)
-	idbSuccessSet = make(map[string]bool)   // cacheKey -> true (only successful calls)
-	pgiSuccessSet = make(map[string]bool)   // paymentId -> true (only successful calls)
-	cacheMutex    sync.RWMutex
+	// Each cache is LRU-bounded when a max size is configured (default unlimited)
+	gatewayCache  = newLRUCache[gatewayEntry](0) // paymentId -> gateway (only successful lookups)
+	idbSuccessSet = newLRUCache[struct{}](0)     // cacheKey (only successful calls)
+	pgiSuccessSet = newLRUCache[struct{}](0)     // paymentId (only successful calls)
+	// Configuration and other shared simulator state; the caches above have
+	// their own locks (see cache_locks.go)
+	cacheMutex sync.RWMutex
+
+	// Listen port (overridable via PORT)
+	port = "8090"
+
+	// How long shutdown waits for in-flight requests (overridable via SHUTDOWN_GRACE_PERIOD)
+	shutdownGracePeriod = 5 * time.Second
 
-	// Available gateways
+	// Requests currently being served, reported when draining on shutdown
+	inFlightRequests atomic.Int64
+
+	// How long a cached gateway stays valid; 0 means forever (guarded by cacheMutex)
+	gatewayCacheTTL time.Duration
+
+	// Available gateways (guarded by cacheMutex, set via GATEWAYS and adjustable
+	// via /admin/gateways)
 	gateways = []string{"stripe", "adyen", "paypal"}
 
-	// Error probabilities
+	// Error probabilities (guarded by cacheMutex, adjustable via /admin/error-rates)
 	esErrorRate  = 0.1
 	idbErrorRate = 0.1
 	pgiErrorRate = 0.15
+
+	// Per-gateway PGI error probabilities; gateways not listed fall back to pgiErrorRate
+	pgiGatewayErrorRates = make(map[string]float64)
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+
+	loadEnvConfig()
+
+	// The restore finishes before the listener binds, so no request can ever
+	// see the caches empty or half-loaded
+	restoreStart := time.Now()
+	if snapshot, err := loadCaches(cacheFile); err != nil {
+		log.Printf("WARNING: could not restore caches from %s, starting empty: %v", cacheFile, err)
+	} else {
+		entries := snapshot.entries()
+		log.Printf("Restored %d cache entries from %s in %s: %d gateways, %d IDB keys, %d PGI ids, %d named tenants",
+			entries, cacheFile, time.Since(restoreStart).Round(time.Microsecond),
+			len(snapshot.GatewayCache), len(snapshot.IdbSuccessKeys), len(snapshot.PgiSuccessIds), len(snapshot.Tenants))
+	}
+	serverPhase.Store(phaseReady)
+
+	if recordFile != "" {
+		if err := startRecording(recordFile); err != nil {
+			log.Fatalf("Failed to open RECORD_FILE: %v", err)
+		}
+		log.Printf("Recording requests to %s", recordFile)
+	}
+
 	mux := http.NewServeMux()
 
 	// Elasticsearch
-	mux.HandleFunc("GET /elasticsearch/payments/_doc/{paymentId}", handleElasticsearch)
+	mux.HandleFunc("GET /elasticsearch/payments/_doc/{paymentId}", instrument("es", handleElasticsearch))
+	mux.HandleFunc("HEAD /elasticsearch/payments/_doc/{paymentId}", instrument("es_head", handleElasticsearchHead))
+	mux.HandleFunc("POST /elasticsearch/payments/_mget", instrument("es_mget", handleElasticsearchMget))
+	mux.HandleFunc("POST /elasticsearch/payments/_search", instrument("es_search", handleElasticsearchSearch))
+	mux.HandleFunc("POST /elasticsearch/_bulk", instrument("es_bulk", handleElasticsearchBulk))
 
 	// IDB Facade
-	mux.HandleFunc("POST /idb-facade/api/v1/payments/notify", handleIdbNotify)
+	mux.HandleFunc("POST /idb-facade/api/v1/payments/notify", instrument("idb", handleIdbNotify))
 
 	// PGI Gateway
-	mux.HandleFunc("POST /pgi-gateway/api/v1/payments/{paymentId}/check-status", handlePgiCheckStatus)
+	mux.HandleFunc("POST /pgi-gateway/api/v1/payments/{paymentId}/check-status", instrument("pgi", rateLimited("pgi", handlePgiCheckStatus)))
+	mux.HandleFunc("POST /pgi-gateway/api/v1/payments/{paymentId}/refund", instrument("pgi_refund", handlePgiRefund))
+	mux.HandleFunc("POST /pgi-gateway/api/v1/payments/{paymentId}/capture", instrument("pgi_capture", handlePgiCapture))
+	mux.HandleFunc("POST /pgi-gateway/api/v1/payments/{paymentId}/dispute", instrument("pgi_dispute", handlePgiDispute))
+	mux.HandleFunc("GET /pgi-gateway/api/v1/payments/{paymentId}/dispute", instrument("pgi_dispute", handleGetPgiDispute))
+	mux.HandleFunc("POST /pgi-gateway/api/v1/payments/{paymentId}/dispute/resolve", instrument("pgi_dispute", handleResolvePgiDispute))
+	mux.HandleFunc("GET /pgi-gateway/api/v1/payments/{paymentId}/stream", instrument("pgi_stream", handlePgiStatusStream))
 
-	// Admin
-	mux.HandleFunc("GET /admin/cache", handleAdminCache)
-	mux.HandleFunc("POST /admin/cache/clear", handleAdminCacheClear)
+	// Admin (guarded by ADMIN_KEY when set)
+	admin := http.NewServeMux()
+	admin.HandleFunc("GET /admin/info", handleAdminInfo)
+	admin.HandleFunc("GET /admin/runtime", handleAdminRuntime)
+	admin.HandleFunc("GET /admin/audit", handleGetAudit)
+	admin.HandleFunc("GET /admin/cache", handleAdminCache)
+	admin.HandleFunc("POST /admin/cache/clear", handleAdminCacheClear)
+	admin.HandleFunc("POST /admin/cache/gateway", handleSeedGatewayCache)
+	admin.HandleFunc("GET /admin/cache/gateway/{key}", handleGetGatewayCacheEntry)
+	admin.HandleFunc("GET /admin/cache/idb/{key}", handleGetSuccessCacheEntry("idb", &idbCacheMutex))
+	admin.HandleFunc("GET /admin/cache/pgi/{key}", handleGetSuccessCacheEntry("pgi", &pgiCacheMutex))
+	admin.HandleFunc("DELETE /admin/cache/gateway/{key}", handleDeleteCacheEntry("gateway", &gatewayCacheMutex))
+	admin.HandleFunc("DELETE /admin/cache/idb/{key}", handleDeleteCacheEntry("idb", &idbCacheMutex))
+	admin.HandleFunc("DELETE /admin/cache/pgi/{key}", handleDeleteCacheEntry("pgi", &pgiCacheMutex))
+	admin.HandleFunc("GET /admin/cache/ttl", handleGetCacheTTL)
+	admin.HandleFunc("POST /admin/cache/ttl", handleSetCacheTTL)
+	admin.HandleFunc("GET /admin/cache/limits", handleGetCacheLimits)
+	admin.HandleFunc("POST /admin/cache/limits", handleSetCacheLimits)
+	admin.HandleFunc("GET /admin/cache/export", handleExportCache)
+	admin.HandleFunc("POST /admin/cache/import", handleImportCache)
+	admin.HandleFunc("GET /admin/tenants", handleListTenants)
+	admin.HandleFunc("POST /admin/snapshot", handleAdminSnapshot)
+	admin.HandleFunc("POST /admin/restore", handleAdminRestore)
+	admin.HandleFunc("POST /admin/stats/reset", handleAdminStatsReset)
+	admin.HandleFunc("GET /admin/error-rates", handleGetErrorRates)
+	admin.HandleFunc("POST /admin/error-rates", handleSetErrorRates)
+	admin.HandleFunc("GET /admin/latency", handleGetLatency)
+	admin.HandleFunc("POST /admin/latency", handleSetLatency)
+	admin.HandleFunc("GET /admin/latency/regions", handleGetRegionLatency)
+	admin.HandleFunc("POST /admin/latency/regions", handleSetRegionLatency)
+	admin.HandleFunc("GET /admin/payment-status", handleGetPaymentStatusConfig)
+	admin.HandleFunc("POST /admin/payment-status", handleSetPaymentStatusConfig)
+	admin.HandleFunc("GET /admin/payment-status/polls", handleGetPaymentPolls)
+	admin.HandleFunc("POST /admin/payment-status/polls", handleSetPaymentPolls)
+	admin.HandleFunc("DELETE /admin/payment-status/polls/{paymentId}", handleDeletePaymentPolls)
+	admin.HandleFunc("GET /admin/rate-limit", handleGetRateLimit)
+	admin.HandleFunc("POST /admin/rate-limit", handleSetRateLimit)
+	admin.HandleFunc("GET /admin/webhook", handleGetWebhook)
+	admin.HandleFunc("POST /admin/webhook", handleSetWebhook)
+	admin.HandleFunc("GET /admin/faults", handleGetFaults)
+	admin.HandleFunc("POST /admin/faults", handleSetFaults)
+	admin.HandleFunc("GET /admin/fault-burst", handleGetFaultBursts)
+	admin.HandleFunc("POST /admin/fault-burst", handleAddFaultBurst)
+	admin.HandleFunc("DELETE /admin/fault-burst", handleClearFaultBursts)
+	admin.HandleFunc("GET /admin/clock", handleGetClock)
+	admin.HandleFunc("POST /admin/clock", handleSetClock)
+	admin.HandleFunc("DELETE /admin/clock", handleResetClock)
+	admin.HandleFunc("GET /admin/clock-skew", handleGetClockSkew)
+	admin.HandleFunc("POST /admin/clock-skew", handleSetClockSkew)
+	admin.HandleFunc("GET /admin/unavailable", handleGetUnavailable)
+	admin.HandleFunc("POST /admin/unavailable", handleSetUnavailable)
+	admin.HandleFunc("GET /admin/payment-id-pattern", handleGetPaymentIdPattern)
+	admin.HandleFunc("POST /admin/payment-id-pattern", handleSetPaymentIdPattern)
+	admin.HandleFunc("GET /admin/missing", handleGetMissing)
+	admin.HandleFunc("POST /admin/missing", handleAddMissing)
+	admin.HandleFunc("DELETE /admin/missing/{paymentId}", handleRemoveMissing)
+	admin.HandleFunc("GET /admin/pgi-outcome", handleGetPgiOutcomes)
+	admin.HandleFunc("POST /admin/pgi-outcome", handleSetPgiOutcome)
+	admin.HandleFunc("DELETE /admin/pgi-outcome/{paymentId}", handleDeletePgiOutcome)
+	admin.HandleFunc("GET /admin/idb-limits", handleGetIdbLimits)
+	admin.HandleFunc("POST /admin/idb-limits", handleSetIdbLimits)
+	admin.HandleFunc("GET /admin/idb-item-failures", handleGetIdbItemFailures)
+	admin.HandleFunc("POST /admin/idb-item-failures", handleSetIdbItemFailures)
+	admin.HandleFunc("GET /admin/idb-dedup", handleGetIdbDedup)
+	admin.HandleFunc("POST /admin/idb-dedup", handleSetIdbDedup)
+	admin.HandleFunc("GET /admin/es-consistency", handleGetEsConsistency)
+	admin.HandleFunc("POST /admin/es-consistency", handleSetEsConsistency)
+	admin.HandleFunc("GET /admin/concurrency", handleGetConcurrency)
+	admin.HandleFunc("POST /admin/concurrency", handleSetConcurrency)
+	admin.HandleFunc("GET /admin/maintenance", handleGetMaintenance)
+	admin.HandleFunc("POST /admin/maintenance", handleSetMaintenance)
+	admin.HandleFunc("GET /admin/gateways", handleGetGateways)
+	admin.HandleFunc("POST /admin/gateways", handleAddGateway)
+	admin.HandleFunc("DELETE /admin/gateways/{name}", handleRemoveGateway)
+	admin.HandleFunc("GET /admin/gateways/distribution", handleGetGatewayDistribution)
+	admin.HandleFunc("GET /admin/gateways/weights", handleGetGatewayWeights)
+	admin.HandleFunc("POST /admin/gateways/weights", handleSetGatewayWeights)
+	admin.HandleFunc("GET /admin/error-rates/pgi-gateways", handleGetPgiGatewayErrorRates)
+	admin.HandleFunc("POST /admin/error-rates/pgi-gateways", handleSetPgiGatewayErrorRates)
+	admin.HandleFunc("GET /admin/fees", handleGetFees)
+	admin.HandleFunc("POST /admin/fees", handleSetFees)
+	admin.HandleFunc("GET /admin/reconciliation", handleReconciliation)
+	mux.Handle("/admin/", requireAdminKey(withAudit(withRouteProblems(admin))))
+	mux.HandleFunc("GET /admin/ui", handleAdminUI)
 
-	// Health
-	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-	})
+	// Payment fixtures; they change simulator state, so they share the admin key
+	mux.Handle("GET /payments", requireAdminKey(http.HandlerFunc(handleListPayments)))
+	mux.Handle("POST /payments", requireAdminKey(withAudit(http.HandlerFunc(handleCreatePayment))))
 
-	log.Println("Mock server starting on :8090")
-	log.Println("Error rates: ES=10%, IDB=10%, PGI=15% (errors NOT cached, retries can succeed)")
+	// Metrics
+	mux.Handle("GET /metrics", promhttp.Handler())
+
+	// API contract
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+
+	// Health (/health is kept as an alias of the liveness probe)
+	mux.HandleFunc("GET /health", handleLiveness)
+	mux.HandleFunc("GET /health/live", handleLiveness)
+	mux.HandleFunc("GET /health/ready", handleReadiness)
+
+	log.Printf("Mock server %s (commit %s, %s) starting on :%s", version, gitCommit, runtime.Version(), port)
+	if deterministicMode {
+		log.Println("*** DETERMINISTIC MODE: random errors, hangs, resets and payment failures are disabled; only explicitly requested errors occur ***")
+		log.Printf("Error rates: ignored (configured ES=%.0f%%, IDB=%.0f%%, PGI=%.0f%%)",
+			esErrorRate*100, idbErrorRate*100, pgiErrorRate*100)
+	} else {
+		log.Printf("Error rates: ES=%.0f%%, IDB=%.0f%%, PGI=%.0f%% (errors NOT cached, retries can succeed)",
+			esErrorRate*100, idbErrorRate*100, pgiErrorRate*100)
+	}
+	log.Printf("Effective config: PORT=%s ES_ERROR_RATE=%g IDB_ERROR_RATE=%g PGI_ERROR_RATE=%g LOG_LEVEL=%s CACHE_FILE=%s",
+		port, esErrorRate, idbErrorRate, pgiErrorRate, strings.ToLower(logLevel.Level().String()), cacheFile)
+	log.Printf("Server timeouts: read=%s write=%s idle=%s (0s = none)", serverReadTimeout, serverWriteTimeout, serverIdleTimeout)
+	log.Printf("Gateways: %s (strategy: %s)", strings.Join(gateways, ", "), gatewayStrategy)
+	if feeRounding != roundingHalfUp {
+		log.Printf("FEE_ROUNDING=%s: exact half-unit fees round to the even neighbour", feeRounding)
+	}
+	if timestampFormat != timestampRFC3339 {
+		log.Printf("TIMESTAMP_FORMAT=%s: IDB and PGI response timestamps use this format", timestampFormat)
+	}
+	if !pgiGatewayValidation {
+		log.Println("PGI_GATEWAY_VALIDATION=false: check-status accepts any X-Gateway-Name, including none")
+	}
+	if strictGatewayCheck {
+		log.Println("STRICT_GATEWAY_CHECK=true: check-status rejects an X-Gateway-Name that differs from the ES assignment with 409")
+	}
+	if rngSeed != nil {
+		log.Printf("RNG_SEED=%d: random decisions are reproducible for the same request order", *rngSeed)
+	}
+	if adminKey == "" {
+		log.Println("WARNING: ADMIN_KEY is not set, admin endpoints are unauthenticated")
+	}
 	log.Println("Endpoints:")
 	log.Println("  GET  /elasticsearch/payments/_doc/{paymentId}")
+	log.Println("  HEAD /elasticsearch/payments/_doc/{paymentId}")
+	log.Println("  POST /elasticsearch/payments/_mget")
+	log.Println("  POST /elasticsearch/payments/_search")
+	log.Println("  POST /elasticsearch/_bulk")
 	log.Println("  POST /idb-facade/api/v1/payments/notify")
 	log.Println("  POST /pgi-gateway/api/v1/payments/{paymentId}/check-status")
+	log.Println("  POST /pgi-gateway/api/v1/payments/{paymentId}/refund")
+	log.Println("  POST /pgi-gateway/api/v1/payments/{paymentId}/capture")
+	log.Println("  POST /pgi-gateway/api/v1/payments/{paymentId}/dispute")
+	log.Println("  GET  /pgi-gateway/api/v1/payments/{paymentId}/dispute")
+	log.Println("  POST /pgi-gateway/api/v1/payments/{paymentId}/dispute/resolve")
+	log.Println("  GET  /pgi-gateway/api/v1/payments/{paymentId}/stream")
+	log.Println("  GET  /payments")
+	log.Println("  POST /payments")
+	log.Println("  GET  /admin/ui")
+	log.Println("  GET  /admin/info")
+	log.Println("  GET  /admin/runtime")
+	log.Println("  GET  /admin/audit")
 	log.Println("  GET  /admin/cache")
 	log.Println("  POST /admin/cache/clear")
+	log.Println("  POST /admin/cache/gateway")
+	log.Println("  GET  /admin/cache/gateway/{paymentId}")
+	log.Println("  GET  /admin/cache/idb/{cacheKey}")
+	log.Println("  GET  /admin/cache/pgi/{paymentId}")
+	log.Println("  DELETE /admin/cache/gateway/{paymentId}")
+	log.Println("  DELETE /admin/cache/idb/{cacheKey}")
+	log.Println("  DELETE /admin/cache/pgi/{paymentId}")
+	log.Println("  GET  /admin/cache/ttl")
+	log.Println("  POST /admin/cache/ttl")
+	log.Println("  GET  /admin/cache/limits")
+	log.Println("  POST /admin/cache/limits")
+	log.Println("  GET  /admin/cache/export")
+	log.Println("  POST /admin/cache/import")
+	log.Println("  GET  /admin/tenants")
+	log.Println("  POST /admin/snapshot")
+	log.Println("  POST /admin/restore")
+	log.Println("  POST /admin/stats/reset")
+	log.Println("  GET  /admin/error-rates")
+	log.Println("  POST /admin/error-rates")
+	log.Println("  GET  /admin/latency")
+	log.Println("  POST /admin/latency")
+	log.Println("  GET  /admin/latency/regions")
+	log.Println("  POST /admin/latency/regions")
+	log.Println("  GET  /admin/payment-status")
+	log.Println("  POST /admin/payment-status")
+	log.Println("  GET  /admin/payment-status/polls")
+	log.Println("  POST /admin/payment-status/polls")
+	log.Println("  DELETE /admin/payment-status/polls/{paymentId}")
+	log.Println("  GET  /admin/rate-limit")
+	log.Println("  POST /admin/rate-limit")
+	log.Println("  GET  /admin/webhook")
+	log.Println("  POST /admin/webhook")
+	log.Println("  GET  /admin/faults")
+	log.Println("  POST /admin/faults")
+	log.Println("  GET  /admin/fault-burst")
+	log.Println("  POST /admin/fault-burst")
+	log.Println("  DELETE /admin/fault-burst")
+	log.Println("  GET  /admin/clock")
+	log.Println("  POST /admin/clock")
+	log.Println("  DELETE /admin/clock")
+	log.Println("  GET  /admin/clock-skew")
+	log.Println("  POST /admin/clock-skew")
+	log.Println("  GET  /admin/unavailable")
+	log.Println("  POST /admin/unavailable")
+	log.Println("  GET  /admin/payment-id-pattern")
+	log.Println("  POST /admin/payment-id-pattern")
+	log.Println("  GET  /admin/missing")
+	log.Println("  POST /admin/missing")
+	log.Println("  DELETE /admin/missing/{paymentId}")
+	log.Println("  GET  /admin/pgi-outcome")
+	log.Println("  POST /admin/pgi-outcome")
+	log.Println("  DELETE /admin/pgi-outcome/{paymentId}")
+	log.Println("  GET  /admin/idb-limits")
+	log.Println("  POST /admin/idb-limits")
+	log.Println("  GET  /admin/idb-item-failures")
+	log.Println("  POST /admin/idb-item-failures")
+	log.Println("  GET  /admin/idb-dedup")
+	log.Println("  POST /admin/idb-dedup")
+	log.Println("  GET  /admin/es-consistency")
+	log.Println("  POST /admin/es-consistency")
+	log.Println("  GET  /admin/concurrency")
+	log.Println("  POST /admin/concurrency")
+	log.Println("  GET  /admin/maintenance")
+	log.Println("  POST /admin/maintenance")
+	log.Println("  GET  /admin/gateways")
+	log.Println("  POST /admin/gateways")
+	log.Println("  DELETE /admin/gateways/{name}")
+	log.Println("  GET  /admin/gateways/distribution")
+	log.Println("  GET  /admin/gateways/weights")
+	log.Println("  POST /admin/gateways/weights")
+	log.Println("  GET  /admin/error-rates/pgi-gateways")
+	log.Println("  POST /admin/error-rates/pgi-gateways")
+	log.Println("  GET  /admin/fees")
+	log.Println("  POST /admin/fees")
+	log.Println("  GET  /admin/reconciliation")
+	log.Println("  GET  /metrics")
+	log.Println("  GET  /openapi.json")
 	log.Println("  GET  /health")
+	log.Println("  GET  /health/live")
+	log.Println("  GET  /health/ready")
+
+	server := &http.Server{
+		Addr:         ":" + port,
+		Handler:      trackInFlight(withRequestId(withRecording(withCORS(withGzip(withTenant(withRouteProblems(mux))))))),
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
+		IdleTimeout:  serverIdleTimeout,
+	}
+	if tlsSelfSigned {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			log.Fatalf("Failed to generate self-signed certificate: %v", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	go func() {
+		var err error
+		switch {
+		case tlsCertFile != "":
+			log.Printf("Serving HTTPS with certificate %s", tlsCertFile)
+			err = server.ListenAndServeTLS(tlsCertFile, tlsKeyFile)
+		case tlsSelfSigned:
+			log.Println("Serving HTTPS with an ephemeral self-signed certificate")
+			err = server.ListenAndServeTLS("", "")
+		default:
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+	log.Printf("Received %s, shutting down", sig)
+
+	// Fail readiness first so orchestrators stop routing here before the
+	// listener goes away
+	serverPhase.Store(phaseDraining)
+	if shutdownDrainDelay > 0 {
+		log.Printf("Reporting not ready for %s before draining", shutdownDrainDelay)
+		time.Sleep(shutdownDrainDelay)
+	}
 
-	if err := http.ListenAndServe(":8090", mux); err != nil {
-		log.Fatal(err)
+	// Shutdown stops accepting connections and waits for in-flight handlers,
+	// including those sleeping in simulated latency, up to the grace period
+	draining := inFlightRequests.Load()
+	log.Printf("Draining %d in-flight request(s) (grace period %s)", draining, shutdownGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("WARNING: shutdown did not complete cleanly, %d request(s) abandoned: %v", inFlightRequests.Load(), err)
+	} else {
+		log.Printf("Drained %d in-flight request(s)", draining)
+	}
+	stopRecording()
+
+	if err := saveCaches(cacheFile); err != nil {
+		log.Printf("WARNING: failed to persist caches to %s: %v", cacheFile, err)
+	} else {
+		log.Printf("Caches persisted to %s", cacheFile)
 	}
 }
 
 func handleElasticsearch(w http.ResponseWriter, r *http.Request) {
 	paymentId := r.PathValue("paymentId")
 	if paymentId == "" {
-		http.Error(w, "Payment ID required", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, codeInvalidPaymentId, "Bad Request", "Payment ID required")
+		return
+	}
+	if !validatePaymentId(w, paymentId) {
 		return
 	}
 
-	log.Printf("[ES] Looking up gateway for payment: %s", paymentId)
+	reqLog := requestLogger(r, "es", paymentId, "")
+	reqLog.Debug("Looking up gateway")
 
-	// Check if we already have a successful result cached
-	cacheMutex.RLock()
-	if gateway, exists := gatewayCache[paymentId]; exists {
-		cacheMutex.RUnlock()
-		log.Printf("[ES] Returning cached gateway '%s' for payment: %s", gateway, paymentId)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]any{
-			"_index": "payments",
-			"_id":    paymentId,
-			"_source": map[string]string{
-				"paymentId":   paymentId,
-				"gatewayName": gateway,
-			},
-		})
+	if connectionFault(w, r, reqLog, "es") || handleForcedError(w, r, reqLog, "es", codeEsInternal) {
 		return
 	}
-	cacheMutex.RUnlock()
 
-	// No cached result - randomly decide if this call fails
-	if rand.Float64() < esErrorRate {
-		log.Printf("[ES] Random error for payment: %s (will succeed on retry)", paymentId)
+	if paymentMissing(paymentId) {
+		reqLog.Debug("Payment marked missing")
+		simulateLatency(r, "es")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(notFoundDocument(paymentId))
+		return
+	}
+	if gatewayPending(requestCaches(r).gateway, paymentId, clockNow()) {
+		reqLog.Debug("Document not yet visible")
+		simulateLatency(r, "es")
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Elasticsearch internal error"})
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(notFoundDocument(paymentId))
 		return
 	}
 
-	// Success - determine gateway and cache it
-	gateway := determineGateway(paymentId)
+	gateway, ok := lookupGateway(requestCaches(r).gateway, paymentId, r.Header.Get("X-Customer-Id"), forceSuccessRequested(r), reqLog)
+	if !ok {
+		writeInjectedError(w, "es", codeEsInternal)
+		return
+	}
 
-	cacheMutex.Lock()
-	gatewayCache[paymentId] = gateway
-	cacheMutex.Unlock()
+	body, _ := json.Marshal(paymentDocument(paymentId, gateway))
+	body = append(body, '\n')
+	etag := documentETag(body)
 
-	log.Printf("[ES] Returning gateway '%s' for payment: %s (cached)", gateway, paymentId)
+	simulateLatency(r, "es")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		reqLog.Debug("Document unchanged", "etag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
-		"_index": "payments",
-		"_id":    paymentId,
-		"_source": map[string]string{
-			"paymentId":   paymentId,
-			"gatewayName": gateway,
-		},
-	})
+	w.Write(body)
+}
+
+// lookupGateway resolves a payment's gateway from cache (the tenant's gateway
+// cache), or rolls for an injected error and then assigns and caches one. A
+// non-empty customerId routes the payment to that customer's gateway instead
+// of hashing the payment ID. It reports false when an error was injected.
+func lookupGateway(cache *lruCache[gatewayEntry], paymentId, customerId string, forceSuccess bool, reqLog *slog.Logger) (string, bool) {
+	// Check if we already have a successful result cached
+	ttl := currentGatewayCacheTTL()
+	gatewayCacheMutex.Lock()
+	if entry, exists := cache.Get(paymentId); exists && !entry.expired(clockNow(), ttl) {
+		gatewayCacheMutex.Unlock()
+		recordCacheLookup("gateway", true)
+		reqLog.Debug("Returning cached gateway", "gateway", entry.Gateway)
+		return entry.Gateway, true
+	}
+	gatewayCacheMutex.Unlock()
+	recordCacheLookup("gateway", false)
+
+	// No cached result - randomly decide if this call fails (unless success is forced)
+	if !forceSuccess && rollFailure(burstErrorRate("es", currentErrorRates().ES)) {
+		recordError("es", errorInjected)
+		reqLog.Warn("Random error (will succeed on retry)")
+		return "", false
+	}
+
+	// Success - determine gateway and cache it (forced successes are never cached)
+	var gateway string
+	if customerId != "" {
+		gateway = customerGateway(customerId, !forceSuccess)
+		reqLog = reqLog.With("customerId", customerId)
+	} else {
+		gateway = determineGateway(paymentId)
+	}
+
+	if forceSuccess {
+		reqLog.Debug("Returning gateway (forced, not cached)", "gateway", gateway)
+	} else {
+		// A concurrent first lookup may have cached a gateway meanwhile; round-robin
+		// and random could have given it a different one, so the first one wins
+		gatewayCacheMutex.Lock()
+		if entry, exists := cache.Peek(paymentId); exists && !entry.expired(clockNow(), ttl) {
+			gatewayCacheMutex.Unlock()
+			reqLog.Debug("Returning gateway (cached concurrently)", "gateway", entry.Gateway)
+			return entry.Gateway, true
+		}
+		cache.Put(paymentId, gatewayEntry{Gateway: gateway, CachedAt: clockNow()})
+		gatewayCacheMutex.Unlock()
+
+		reqLog.Debug("Returning gateway (cached)", "gateway", gateway)
+	}
+	return gateway, true
 }
 
 func handleIdbNotify(w http.ResponseWriter, r *http.Request) {
@@ -125,153 +521,821 @@ func handleIdbNotify(w http.ResponseWriter, r *http.Request) {
 		PaymentIds  []string `json:"paymentIds"`
 	}
 
+	limits := currentIdbLimits()
+	r.Body = http.MaxBytesReader(w, r.Body, limits.MaxBodyBytes)
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeProblem(w, http.StatusRequestEntityTooLarge, codePayloadTooLarge, "Request Entity Too Large", "Request body exceeds "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+			return
+		}
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if errs := validateIdbNotify(req.GatewayName, req.PaymentIds); len(errs) > 0 {
+		writeValidationProblem(w, errs)
+		return
+	}
+	if limits.MaxBatchSize > 0 && len(req.PaymentIds) > limits.MaxBatchSize {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Batch of "+strconv.Itoa(len(req.PaymentIds))+" paymentIds exceeds the limit of "+strconv.Itoa(limits.MaxBatchSize))
+		return
+	}
+	if dups := duplicateIds(req.PaymentIds); len(dups) > 0 && !strings.EqualFold(r.Header.Get("X-Allow-Duplicates"), "true") {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Duplicate paymentIds in batch: "+strings.Join(dups, ", "))
 		return
 	}
 
-	cacheKey := req.GatewayName + ":" + strings.Join(req.PaymentIds, ",")
-	log.Printf("[IDB] Notify for gateway '%s' with %d payments: %v", req.GatewayName, len(req.PaymentIds), req.PaymentIds)
+	cacheKey := idbCacheKey(req.GatewayName, req.PaymentIds)
+	caches := requestCaches(r)
+	successSet := caches.idb
+	reqLog := requestLogger(r, "idb", "", req.GatewayName).With("cacheKey", cacheKey)
+	reqLog.Debug("Notify received", "count", len(req.PaymentIds), "paymentIds", req.PaymentIds)
 
-	// Check if we already have a successful result cached
-	cacheMutex.RLock()
-	if idbSuccessSet[cacheKey] {
-		cacheMutex.RUnlock()
-		log.Printf("[IDB] Returning cached success for key: %s", cacheKey)
-		time.Sleep(50 * time.Millisecond)
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]any{
-			"status":    "ok",
-			"message":   "Payments notified successfully",
-			"gateway":   req.GatewayName,
-			"count":     len(req.PaymentIds),
-			"timestamp": time.Now().UTC().Format(time.RFC3339),
-		})
+	if connectionFault(w, r, reqLog, "idb") || handleForcedError(w, r, reqLog, "idb", codeIdbInternal) {
 		return
 	}
-	cacheMutex.RUnlock()
 
-	// No cached result - randomly decide if this call fails
-	if rand.Float64() < idbErrorRate {
-		log.Printf("[IDB] Random error for key: %s (will succeed on retry)", cacheKey)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "IDB Facade internal error"})
+	// A client-supplied Idempotency-Key replaces the body-derived cache key:
+	// the first successful response under it is replayed verbatim
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if idempotencyKey != "" {
+		reqLog = reqLog.With("idempotencyKey", idempotencyKey)
+		idbCacheMutex.Lock()
+		stored, exists := caches.idempotencyKeys.Get(idempotencyKey)
+		idbCacheMutex.Unlock()
+		recordCacheLookup("idb", exists)
+		if exists {
+			if stored.Fingerprint != cacheKey {
+				reqLog.Warn("Idempotency key reused with a different request")
+				writeProblem(w, http.StatusUnprocessableEntity, codeIdempotencyKeyReused, "Unprocessable Entity",
+					"Idempotency-Key was already used with a different request")
+				return
+			}
+			reqLog.Debug("Replaying idempotent response")
+			simulateLatency(r, "idb")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.Write(stored.Body)
+			return
+		}
+	} else {
+		if wait := idbDuplicateWait(caches.notifiedAt, cacheKey, clockNow()); wait > 0 {
+			reqLog.Warn("Duplicate notification within dedup window", "retryAfterMs", wait.Milliseconds())
+			writeDuplicateNotification(w, wait)
+			return
+		}
+
+		// Check if we already have a successful result cached
+		idbCacheMutex.Lock()
+		if _, exists := successSet.Get(cacheKey); exists {
+			caches.notifiedAt.Put(cacheKey, clockNow())
+			idbCacheMutex.Unlock()
+			recordCacheLookup("idb", true)
+			reqLog.Debug("Returning cached success")
+			simulateLatency(r, "idb")
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(idbNotifyResponse(req.GatewayName, idbItemResults(req.PaymentIds, true)))
+			return
+		}
+		idbCacheMutex.Unlock()
+		recordCacheLookup("idb", false)
+	}
+
+	// No cached result - randomly decide if this call fails (unless success is forced)
+	forceSuccess := forceSuccessRequested(r)
+	if !forceSuccess && rollFailure(burstErrorRate("idb", currentErrorRates().IDB)) {
+		recordError("idb", errorInjected)
+		reqLog.Warn("Random error (will succeed on retry)")
+		writeInjectedError(w, "idb", codeIdbInternal)
 		return
 	}
 
-	// Success - cache it
-	cacheMutex.Lock()
-	idbSuccessSet[cacheKey] = true
-	cacheMutex.Unlock()
+	// The batch went through, but individual payments may still fail
+	results := idbItemResults(req.PaymentIds, forceSuccess)
+	succeeded := succeededIds(results)
+	if failed := len(results) - len(succeeded); failed > 0 {
+		recordError("idb", errorInjected)
+		markErrorInjected(w)
+		reqLog.Warn("Partial failure", "failed", failed)
+	}
+
+	body, _ := json.Marshal(idbNotifyResponse(req.GatewayName, results))
+	body = append(body, '\n')
+
+	// Success - cache it (forced successes are never cached). An idempotent
+	// response is stored as sent; the body-derived key only remembers batches
+	// where every payment succeeded, so retrying a partial batch re-rolls.
+	if forceSuccess {
+		reqLog.Debug("Forced success (not cached)")
+	} else {
+		idbCacheMutex.Lock()
+		if idempotencyKey != "" {
+			caches.idempotencyKeys.Put(idempotencyKey, idempotentResponse{Fingerprint: cacheKey, Body: body})
+		} else if len(succeeded) == len(results) {
+			successSet.Put(cacheKey, struct{}{})
+			caches.notifiedAt.Put(cacheKey, clockNow())
+		}
+		idbCacheMutex.Unlock()
+	}
+	sendIdbCallback(requestTrace(r), req.GatewayName, succeeded)
 
-	time.Sleep(50 * time.Millisecond)
+	simulateLatency(r, "idb")
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
-		"status":    "ok",
-		"message":   "Payments notified successfully",
-		"gateway":   req.GatewayName,
-		"count":     len(req.PaymentIds),
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-	})
+	w.Write(body)
+}
+
+// duplicateIds returns each ID that appears more than once, in order of its
+// second occurrence.
+func duplicateIds(ids []string) []string {
+	seen := make(map[string]int, len(ids))
+	var dups []string
+	for _, id := range ids {
+		seen[id]++
+		if seen[id] == 2 {
+			dups = append(dups, id)
+		}
+	}
+	return dups
+}
+
+// idbCacheKey identifies a notify by gateway and the set of payment IDs, so
+// [a,b], [b,a] and [a,a,b] all map to the same cache entry.
+func idbCacheKey(gateway string, paymentIds []string) string {
+	ids := slices.Clone(paymentIds)
+	slices.Sort(ids)
+	return gateway + ":" + strings.Join(slices.Compact(ids), ",")
 }
 
 func handlePgiCheckStatus(w http.ResponseWriter, r *http.Request) {
 	paymentId := r.PathValue("paymentId")
 	gateway := r.Header.Get("X-Gateway-Name")
+	if !validatePaymentId(w, paymentId) || !validatePgiGateway(w, gateway) {
+		return
+	}
 
-	log.Printf("[PGI] Check status for payment '%s' on gateway '%s'", paymentId, gateway)
+	reqLog := requestLogger(r, "pgi", paymentId, gateway)
+	reqLog.Debug("Check status")
+	caches := requestCaches(r)
+	if !checkGatewayMismatch(w, reqLog, caches.gateway, paymentId, gateway) {
+		return
+	}
+
+	if connectionFault(w, r, reqLog, "pgi") || handleForcedError(w, r, reqLog, "pgi", codePgiInternal) {
+		return
+	}
 
 	// Check if we already have a successful result cached
-	cacheMutex.RLock()
-	if pgiSuccessSet[paymentId] {
-		cacheMutex.RUnlock()
-		log.Printf("[PGI] Returning cached success for payment: %s", paymentId)
-		time.Sleep(30 * time.Millisecond)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusAccepted)
-		json.NewEncoder(w).Encode(map[string]any{
-			"status":    "accepted",
-			"paymentId": paymentId,
-			"gateway":   gateway,
-			"message":   "Status check triggered",
-			"timestamp": time.Now().UTC().Format(time.RFC3339),
-		})
+	pgiCacheMutex.Lock()
+	if _, exists := caches.pgi.Get(paymentId); exists {
+		pgiCacheMutex.Unlock()
+		recordCacheLookup("pgi", true)
+		reqLog.Debug("Returning cached success")
+		simulateLatency(r, "pgi")
+		writePgiStatus(w, paymentId, gateway, pollPaymentStatus(paymentId, clockNow()))
 		return
 	}
-	cacheMutex.RUnlock()
+	pgiCacheMutex.Unlock()
+	recordCacheLookup("pgi", false)
 
-	// No cached result - randomly decide if this call fails
-	if rand.Float64() < pgiErrorRate {
-		log.Printf("[PGI] Random error for payment: %s (will succeed on retry)", paymentId)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "PGI Gateway internal error"})
+	// No cached result - randomly decide if this call fails (unless success is forced)
+	forceSuccess := forceSuccessRequested(r)
+	if !forceSuccess && rollFailure(burstErrorRate("pgi", pgiErrorRateFor(gateway))) {
+		recordError("pgi", errorInjected)
+		reqLog.Warn("Random error (will succeed on retry)")
+		writeInjectedError(w, "pgi", codePgiInternal)
+		return
+	}
+
+	// Success - cache it (forced successes are never cached)
+	if forceSuccess {
+		reqLog.Debug("Forced success (not cached)")
+	} else {
+		pgiCacheMutex.Lock()
+		caches.pgi.Put(paymentId, struct{}{})
+		pgiCacheMutex.Unlock()
+	}
+
+	simulateLatency(r, "pgi")
+	writePgiStatus(w, paymentId, gateway, pollPaymentStatus(paymentId, clockNow()))
+}
+
+// writePgiStatus writes the 202 check-status response. "status" is the
+// gateway's decision ("accepted" unless set via /admin/pgi-outcome, with an
+// optional "reason"); "paymentStatus" is the payment's lifecycle state after
+// this poll. Succeeded and captured payments also report their settlement:
+// amount, fee per the gateway's fee rule and netAmount.
+func writePgiStatus(w http.ResponseWriter, paymentId, gateway string, state paymentState) {
+	outcome := lookupPgiOutcome(paymentId)
+	body := map[string]any{
+		"status":        outcome.Outcome,
+		"paymentId":     paymentId,
+		"gateway":       gateway,
+		"paymentStatus": state.Status,
+		"message":       "Status check triggered",
+		"timestamp":     formatTimestamp(clockNow()),
+	}
+	if outcome.Reason != "" {
+		body["reason"] = outcome.Reason
+	}
+	if state.Status == statusSucceeded || state.Status == statusCaptured {
+		paid := settlementFor(paymentId, gateway)
+		body["amount"] = paid.Amount
+		body["currency"] = paid.Currency
+		body["fee"] = paid.Fee
+		body["netAmount"] = paid.NetAmount
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(body)
+}
+
+// handleForcedError short-circuits a request carrying an X-Force-Error header
+// (e.g. "500" or "503") with that status, bypassing the caches and the RNG.
+// It reports whether the response has been written.
+func handleForcedError(w http.ResponseWriter, r *http.Request, reqLog *slog.Logger, endpoint, code string) bool {
+	value := r.Header.Get("X-Force-Error")
+	if value == "" {
+		return false
+	}
+
+	status, err := strconv.Atoi(value)
+	if err != nil || status < 400 || status > 599 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "X-Force-Error must be an HTTP status code between 400 and 599")
+		return true
+	}
+
+	reqLog.Warn("Forced error via X-Force-Error", "status", status)
+	recordError(endpoint, errorForced)
+	markErrorInjected(w)
+	writeProblem(w, status, code, internalErrorTitles[code], "Forced via X-Force-Error")
+	return true
+}
+
+// forceSuccessRequested reports whether the request asks to skip the random
+// error roll via X-Force-Success: true.
+func forceSuccessRequested(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("X-Force-Success"), "true")
+}
+
+// handleAdminCache reports cache sizes, limits and request stats. Entries are
+// only listed when ?limit= and/or ?offset= is given, one page per cache in
+// key order, so the default response stays small however big the caches get.
+// ?gateway= narrows the listing to payments mapped to that gateway (and IDB
+// keys for it) and implies paging. ?tenant= reports that tenant's caches
+// instead of the default tenant's.
+func handleAdminCache(w http.ResponseWriter, r *http.Request) {
+	page, paged, err := parsePage(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+		return
+	}
+	caches, ok := adminTenant(w, r, false)
+	if !ok {
+		return
+	}
+	gateway := r.URL.Query().Get("gateway")
+	if gateway != "" {
+		if !slices.Contains(currentGateways(), gateway) {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Unknown gateway '"+gateway+"'")
+			return
+		}
+		paged = true
+	}
+
+	ttl := currentGatewayCacheTTL()
+	rlockCaches()
+	defer runlockCaches()
+
+	response := map[string]any{
+		"description":       "Only successful responses are cached",
+		"gatewayCacheSize":  caches.gateway.Len(),
+		"gatewayCacheTtlMs": ttl.Milliseconds(),
+		"idbSuccessCount":   caches.idb.Len(),
+		"pgiSuccessCount":   caches.pgi.Len(),
+		"cacheLimits":       currentCacheStats(),
+		"requestStats":      snapshotRequestStats(),
+		"regionRequests":    snapshotRegionRequests(),
+		"cacheLookups":      snapshotCacheLookups(),
+	}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		response["tenant"] = tenant
+	}
+	if paged {
+		paymentIds := caches.gateway.Keys()
+		idbKeys := caches.idb.Keys()
+		pgiIds := caches.pgi.Keys()
+		if gateway != "" {
+			onGateway := func(paymentId string) bool {
+				entry, ok := caches.gateway.Peek(paymentId)
+				return ok && entry.Gateway == gateway
+			}
+			paymentIds = slices.DeleteFunc(paymentIds, func(id string) bool { return !onGateway(id) })
+			idbKeys = slices.DeleteFunc(idbKeys, func(key string) bool { return !strings.HasPrefix(key, gateway+":") })
+			pgiIds = slices.DeleteFunc(pgiIds, func(id string) bool { return !onGateway(id) })
+			response["gateway"] = gateway
+			response["gatewayMatches"] = len(paymentIds)
+		}
+
+		gatewayPage := make(map[string]string)
+		for _, paymentId := range page.apply(paymentIds) {
+			entry, _ := caches.gateway.Peek(paymentId)
+			gatewayPage[paymentId] = entry.Gateway
+		}
+		response["offset"] = page.Offset
+		response["limit"] = page.Limit
+		response["gatewayCache"] = gatewayPage
+		response["idbSuccessKeys"] = page.apply(idbKeys)
+		response["pgiSuccessIds"] = page.apply(pgiIds)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// gatewayEntry is a cached gateway assignment with its insertion time.
+type gatewayEntry struct {
+	Gateway  string
+	CachedAt time.Time
+}
+
+func (e gatewayEntry) expired(now time.Time, ttl time.Duration) bool {
+	return ttl > 0 && now.Sub(e.CachedAt) >= ttl
+}
+
+// gatewayCacheView flattens a gateway cache to paymentId -> gateway. Caller
+// must hold gatewayCacheMutex.
+func gatewayCacheView(cache *lruCache[gatewayEntry]) map[string]string {
+	view := make(map[string]string, cache.Len())
+	cache.Range(func(paymentId string, entry gatewayEntry) {
+		view[paymentId] = entry.Gateway
+	})
+	return view
+}
+
+// currentGatewayCacheTTL returns the gateway cache TTL. Read it before taking
+// gatewayCacheMutex, since cacheMutex comes first in the lock order.
+func currentGatewayCacheTTL() time.Duration {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return gatewayCacheTTL
+}
+
+func handleGetCacheTTL(w http.ResponseWriter, _ *http.Request) {
+	ttl := currentGatewayCacheTTL()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"gatewayTtlMs": ttl.Milliseconds()})
+}
+
+// handleSetCacheTTL sets the gateway cache TTL, e.g. {"gatewayTtlMs":60000}.
+// Entries older than the TTL are treated as misses and re-rolled; 0 disables
+// expiry.
+func handleSetCacheTTL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		GatewayTtlMs int64 `json:"gatewayTtlMs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.GatewayTtlMs < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "gatewayTtlMs must not be negative")
 		return
 	}
 
-	// Success - cache it
 	cacheMutex.Lock()
-	pgiSuccessSet[paymentId] = true
+	gatewayCacheTTL = time.Duration(req.GatewayTtlMs) * time.Millisecond
 	cacheMutex.Unlock()
 
-	time.Sleep(30 * time.Millisecond)
+	logger.Info("Gateway cache TTL updated", "endpoint", "admin", "gatewayTtlMs", req.GatewayTtlMs)
+
+	handleGetCacheTTL(w, r)
+}
+
+// currentCacheStats reports size, limit and evictions per cache. Caller must
+// hold all cache locks (rlockCaches).
+func currentCacheStats() map[string]cacheStats {
+	return map[string]cacheStats{
+		"gateway": gatewayCache.stats(),
+		"idb":     idbSuccessSet.stats(),
+		"pgi":     pgiSuccessSet.stats(),
+	}
+}
+
+func handleGetCacheLimits(w http.ResponseWriter, _ *http.Request) {
+	rlockCaches()
+	defer runlockCaches()
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]any{
-		"status":    "accepted",
-		"paymentId": paymentId,
-		"gateway":   gateway,
-		"message":   "Status check triggered",
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	json.NewEncoder(w).Encode(currentCacheStats())
+}
+
+// handleSetCacheLimits sets the max entries per cache, e.g.
+// {"gateway":10000,"idb":5000,"pgi":5000}. 0 means unlimited; shrinking a
+// cache below its current size evicts the least recently used entries. The
+// limits apply to every tenant's caches, each counted on its own.
+func handleSetCacheLimits(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Gateway *int `json:"gateway"`
+		IDB     *int `json:"idb"`
+		PGI     *int `json:"pgi"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	for name, limit := range map[string]*int{"gateway": req.Gateway, "idb": req.IDB, "pgi": req.PGI} {
+		if limit != nil && *limit < 0 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Limit '"+name+"' must not be negative")
+			return
+		}
+	}
+
+	lockCaches()
+	if req.Gateway != nil {
+		gatewayCache.SetMaxEntries(*req.Gateway)
+		customerGatewayCache.SetMaxEntries(*req.Gateway)
+	}
+	if req.IDB != nil {
+		idbSuccessSet.SetMaxEntries(*req.IDB)
+		idbIdempotencyKeys.SetMaxEntries(*req.IDB)
+		idbNotifiedAt.SetMaxEntries(*req.IDB)
+	}
+	if req.PGI != nil {
+		pgiSuccessSet.SetMaxEntries(*req.PGI)
+	}
+	for _, caches := range namedTenants() {
+		if req.Gateway != nil {
+			caches.gateway.SetMaxEntries(*req.Gateway)
+		}
+		if req.IDB != nil {
+			caches.idb.SetMaxEntries(*req.IDB)
+			caches.idempotencyKeys.SetMaxEntries(*req.IDB)
+			caches.notifiedAt.SetMaxEntries(*req.IDB)
+		}
+		if req.PGI != nil {
+			caches.pgi.SetMaxEntries(*req.PGI)
+		}
+	}
+	limits := currentCacheStats()
+	unlockCaches()
+
+	logger.Info("Cache limits updated", "endpoint", "admin", "limits", limits)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(limits)
+}
+
+// trackInFlight counts requests currently inside the handler chain.
+func trackInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlightRequests.Add(1)
+		defer inFlightRequests.Add(-1)
+		next.ServeHTTP(w, r)
 	})
 }
 
-func handleAdminCache(w http.ResponseWriter, _ *http.Request) {
+// loadEnvConfig overrides the hardcoded defaults from environment variables.
+// Unparseable or out-of-range values are logged and the default is kept.
+func loadEnvConfig() {
+	esErrorRate = envErrorRate("ES_ERROR_RATE", esErrorRate)
+	idbErrorRate = envErrorRate("IDB_ERROR_RATE", idbErrorRate)
+	pgiErrorRate = envErrorRate("PGI_ERROR_RATE", pgiErrorRate)
+
+	adminKey = os.Getenv("ADMIN_KEY")
+
+	// A cert without a key (or vice versa) is a misconfiguration, not a fallback to HTTP
+	tlsCertFile = os.Getenv("TLS_CERT")
+	tlsKeyFile = os.Getenv("TLS_KEY")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		log.Fatal("TLS_CERT and TLS_KEY must be set together")
+	}
+	tlsSelfSigned = strings.EqualFold(os.Getenv("TLS_SELF_SIGNED"), "true")
+	tracingEnabled = strings.EqualFold(os.Getenv("TRACING_ENABLED"), "true")
+	deterministicMode = strings.EqualFold(os.Getenv("DETERMINISTIC"), "true")
+	pgiGatewayValidation = !strings.EqualFold(os.Getenv("PGI_GATEWAY_VALIDATION"), "false")
+	strictGatewayCheck = strings.EqualFold(os.Getenv("STRICT_GATEWAY_CHECK"), "true")
+
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		if origins := parseCORSOrigins(v); len(origins) > 0 {
+			corsOrigins = origins
+		} else {
+			log.Printf("WARNING: invalid CORS_ORIGINS %q, keeping default %v", v, corsOrigins)
+		}
+	}
+
+	if v := os.Getenv("GATEWAYS"); v != "" {
+		if names, err := parseGatewayList(v); err != nil {
+			log.Printf("WARNING: invalid GATEWAYS %q (%v), keeping default %v", v, err, gateways)
+		} else {
+			gateways = names
+		}
+	}
+
+	if v := os.Getenv("GATEWAY_STRATEGY"); v != "" {
+		if strategy := strings.ToLower(v); slices.Contains(gatewayStrategies, strategy) {
+			gatewayStrategy = strategy
+		} else {
+			log.Printf("WARNING: invalid GATEWAY_STRATEGY %q (want one of %s), keeping default %s",
+				v, strings.Join(gatewayStrategies, ", "), gatewayStrategy)
+		}
+	}
+
+	if v := os.Getenv("FEE_ROUNDING"); v != "" {
+		if rounding := strings.ToLower(v); slices.Contains(feeRoundingModes, rounding) {
+			feeRounding = rounding
+		} else {
+			log.Printf("WARNING: invalid FEE_ROUNDING %q (want one of %s), keeping default %s",
+				v, strings.Join(feeRoundingModes, ", "), feeRounding)
+		}
+	}
+
+	if v := os.Getenv("TIMESTAMP_FORMAT"); v != "" {
+		if format := strings.ToLower(v); slices.Contains(timestampFormats, format) {
+			timestampFormat = format
+		} else {
+			log.Printf("WARNING: invalid TIMESTAMP_FORMAT %q (want one of %s), keeping default %s",
+				v, strings.Join(timestampFormats, ", "), timestampFormat)
+		}
+	}
+
+	if v := os.Getenv("WEBHOOK_URL"); v != "" {
+		if err := parseWebhookURL(v); err != nil {
+			log.Printf("WARNING: invalid WEBHOOK_URL %q (%v), webhooks disabled", v, err)
+		} else {
+			webhookURL = v
+		}
+	}
+
+	webhookSecret = os.Getenv("WEBHOOK_SECRET")
+
+	if v := os.Getenv("PAYMENT_ID_PATTERN"); v != "" {
+		if pattern, err := regexp.Compile(v); err != nil {
+			log.Printf("WARNING: invalid PAYMENT_ID_PATTERN %q (%v), keeping default %s", v, err, paymentIdPattern)
+		} else {
+			paymentIdPattern = pattern
+		}
+	}
+
+	recordFile = os.Getenv("RECORD_FILE")
+
+	if v := os.Getenv("CACHE_FILE"); v != "" {
+		cacheFile = v
+	}
+
+	if v := os.Getenv("SHUTDOWN_GRACE_PERIOD"); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			log.Printf("WARNING: invalid SHUTDOWN_GRACE_PERIOD %q (expected a duration like 10s), keeping default %s", v, shutdownGracePeriod)
+		} else {
+			shutdownGracePeriod = d
+		}
+	}
+
+	for name, dst := range map[string]*time.Duration{
+		"SERVER_READ_TIMEOUT":  &serverReadTimeout,
+		"SERVER_WRITE_TIMEOUT": &serverWriteTimeout,
+		"SERVER_IDLE_TIMEOUT":  &serverIdleTimeout,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if d, err := time.ParseDuration(v); err != nil || d < 0 {
+				log.Printf("WARNING: invalid %s %q (expected a duration like 30s, 0 to disable), keeping default %s", name, v, *dst)
+			} else {
+				*dst = d
+			}
+		}
+	}
+
+	if v := os.Getenv("SHUTDOWN_DRAIN_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			log.Printf("WARNING: invalid SHUTDOWN_DRAIN_DELAY %q (expected a duration like 5s), keeping default %s", v, shutdownDrainDelay)
+		} else {
+			shutdownDrainDelay = d
+		}
+	}
+
+	if v := os.Getenv("CACHE_MAX_ENTRIES"); v != "" {
+		if limit, err := strconv.Atoi(v); err != nil || limit < 0 {
+			log.Printf("WARNING: invalid CACHE_MAX_ENTRIES %q, keeping caches unbounded", v)
+		} else {
+			gatewayCache.SetMaxEntries(limit)
+			customerGatewayCache.SetMaxEntries(limit)
+			idbSuccessSet.SetMaxEntries(limit)
+			idbIdempotencyKeys.SetMaxEntries(limit)
+			idbNotifiedAt.SetMaxEntries(limit)
+			pgiSuccessSet.SetMaxEntries(limit)
+		}
+	}
+
+	if v := os.Getenv("MAX_INFLIGHT"); v != "" {
+		if limit, err := strconv.ParseInt(v, 10, 64); err != nil || limit < 0 {
+			log.Printf("WARNING: invalid MAX_INFLIGHT %q, keeping concurrency unlimited", v)
+		} else {
+			maxInFlight.Store(limit)
+		}
+	}
+
+	if v := os.Getenv("IDB_MAX_BODY_BYTES"); v != "" {
+		if limit, err := strconv.ParseInt(v, 10, 64); err != nil || limit < 1 {
+			log.Printf("WARNING: invalid IDB_MAX_BODY_BYTES %q, keeping default %d", v, idbMaxBodyBytes)
+		} else {
+			idbMaxBodyBytes = limit
+		}
+	}
+
+	if v := os.Getenv("IDB_MAX_BATCH_SIZE"); v != "" {
+		if limit, err := strconv.Atoi(v); err != nil || limit < 0 {
+			log.Printf("WARNING: invalid IDB_MAX_BATCH_SIZE %q, keeping default %d", v, idbMaxBatchSize)
+		} else {
+			idbMaxBatchSize = limit
+		}
+	}
+
+	if v := os.Getenv("IDB_DEDUP_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			log.Printf("WARNING: invalid IDB_DEDUP_WINDOW %q (expected a duration like 30s), keeping dedup disabled", v)
+		} else {
+			idbDedupWindow = d
+		}
+	}
+
+	if v := os.Getenv("ES_CONSISTENCY_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			log.Printf("WARNING: invalid ES_CONSISTENCY_DELAY %q (expected a duration like 1s), keeping reads immediately consistent", v)
+		} else {
+			esConsistencyDelay = d
+		}
+	}
+
+	if v := os.Getenv("RNG_SEED"); v != "" {
+		if seed, err := strconv.ParseUint(v, 10, 64); err != nil {
+			log.Printf("WARNING: invalid RNG_SEED %q (expected a non-negative integer), using a random seed", v)
+		} else {
+			seedRNG(seed)
+		}
+	}
+
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			log.Printf("WARNING: invalid LOG_LEVEL %q (expected debug, info, warn or error), keeping default %s", v, logLevel.Level())
+		} else {
+			logLevel.Set(level)
+		}
+	}
+
+	if v := os.Getenv("PORT"); v != "" {
+		if p, err := strconv.Atoi(v); err != nil || p < 1 || p > 65535 {
+			log.Printf("WARNING: invalid PORT %q, keeping default %s", v, port)
+		} else {
+			port = v
+		}
+	}
+}
+
+func envErrorRate(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(rate) || rate < 0 || rate > 1 {
+		log.Printf("WARNING: invalid %s %q (must be a number in [0,1]), keeping default %g", name, v, def)
+		return def
+	}
+	return rate
+}
+
+type errorRates struct {
+	ES  float64 `json:"es"`
+	IDB float64 `json:"idb"`
+	PGI float64 `json:"pgi"`
+}
+
+func currentErrorRates() errorRates {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	return errorRates{ES: esErrorRate, IDB: idbErrorRate, PGI: pgiErrorRate}
+}
+
+func handleGetErrorRates(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentErrorRates())
+}
+
+func handleSetErrorRates(w http.ResponseWriter, r *http.Request) {
+	// Pointers so a partial body only updates the rates it mentions
+	var req struct {
+		ES  *float64 `json:"es"`
+		IDB *float64 `json:"idb"`
+		PGI *float64 `json:"pgi"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	for name, rate := range map[string]*float64{"es": req.ES, "idb": req.IDB, "pgi": req.PGI} {
+		if rate != nil && (*rate < 0 || *rate > 1) {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Error rate '"+name+"' must be between 0 and 1")
+			return
+		}
+	}
+
+	cacheMutex.Lock()
+	if req.ES != nil {
+		esErrorRate = *req.ES
+	}
+	if req.IDB != nil {
+		idbErrorRate = *req.IDB
+	}
+	if req.PGI != nil {
+		pgiErrorRate = *req.PGI
+	}
+	rates := errorRates{ES: esErrorRate, IDB: idbErrorRate, PGI: pgiErrorRate}
+	cacheMutex.Unlock()
+
+	logger.Info("Error rates updated", "endpoint", "admin", "es", rates.ES, "idb", rates.IDB, "pgi", rates.PGI)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
+
+// pgiErrorRateFor returns the PGI error rate for a gateway, falling back to the
+// global pgiErrorRate when the gateway is empty or has no override.
+func pgiErrorRateFor(gateway string) float64 {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	if rate, ok := pgiGatewayErrorRates[gateway]; ok {
+		return rate
+	}
+	return pgiErrorRate
+}
+
+func handleGetPgiGatewayErrorRates(w http.ResponseWriter, _ *http.Request) {
 	cacheMutex.RLock()
 	defer cacheMutex.RUnlock()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]any{
-		"description":      "Only successful responses are cached",
-		"gatewayCacheSize": len(gatewayCache),
-		"gatewayCache":     gatewayCache,
-		"idbSuccessCount":  len(idbSuccessSet),
-		"idbSuccessKeys":   keys(idbSuccessSet),
-		"pgiSuccessCount":  len(pgiSuccessSet),
-		"pgiSuccessIds":    keys(pgiSuccessSet),
+		"default":  pgiErrorRate,
+		"gateways": pgiGatewayErrorRates,
 	})
 }
 
-func handleAdminCacheClear(w http.ResponseWriter, _ *http.Request) {
+// handleSetPgiGatewayErrorRates replaces the per-gateway overrides with the
+// posted map, e.g. {"stripe":0.05,"adyen":0.1,"paypal":0.4}. An empty object
+// removes all overrides.
+func handleSetPgiGatewayErrorRates(w http.ResponseWriter, r *http.Request) {
+	var req map[string]float64
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	for gateway, rate := range req {
+		if rate < 0 || rate > 1 {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Error rate for gateway '"+gateway+"' must be between 0 and 1")
+			return
+		}
+	}
+
 	cacheMutex.Lock()
-	gatewayCache = make(map[string]string)
-	idbSuccessSet = make(map[string]bool)
-	pgiSuccessSet = make(map[string]bool)
+	pgiGatewayErrorRates = make(map[string]float64, len(req))
+	for gateway, rate := range req {
+		pgiGatewayErrorRates[gateway] = rate
+	}
 	cacheMutex.Unlock()
 
-	log.Println("[ADMIN] Cache cleared")
+	logger.Info("PGI per-gateway error rates updated", "endpoint", "admin", "rates", req)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "cache cleared"})
+	handleGetPgiGatewayErrorRates(w, r)
 }
 
 func determineGateway(paymentId string) string {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+
 	// Check for explicit gateway in payment ID
 	for _, gw := range gateways {
 		if strings.Contains(strings.ToLower(paymentId), gw) {
 			return gw
 		}
 	}
-	// Assign based on hash (deterministic)
-	hash := md5.Sum([]byte(paymentId))
-	return gateways[int(hash[0])%len(gateways)]
-}
 
-func keys(m map[string]bool) []string {
-	result := make([]string, 0, len(m))
-	for k := range m {
-		result = append(result, k)
+	switch gatewayStrategy {
+	case strategyRoundRobin:
+		return gateways[(roundRobinNext.Add(1)-1)%uint64(len(gateways))]
+	case strategyRandom:
+		return gateways[int(randFloat64()*float64(len(gateways)))]
+	default:
+		// Assign based on hash (deterministic), honoring any configured weights
+		hash := md5.Sum([]byte(paymentId))
+		return weightedGateway(hash)
 	}
-	return result
 }
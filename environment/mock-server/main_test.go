@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// quietUpstreams turns off injected errors, item failures and latency for
+// the test, restoring the previous settings afterwards.
+func quietUpstreams(t testing.TB) {
+	t.Helper()
+	cacheMutex.Lock()
+	es, idb, pgi, items := esErrorRate, idbErrorRate, pgiErrorRate, idbItemFailureRate
+	latency := maps.Clone(latencyConfig)
+	esErrorRate, idbErrorRate, pgiErrorRate, idbItemFailureRate = 0, 0, 0, 0
+	for endpoint := range latencyConfig {
+		latencyConfig[endpoint] = latencySpec{Distribution: distFixed}
+	}
+	cacheMutex.Unlock()
+
+	t.Cleanup(func() {
+		cacheMutex.Lock()
+		esErrorRate, idbErrorRate, pgiErrorRate, idbItemFailureRate = es, idb, pgi, items
+		latencyConfig = latency
+		cacheMutex.Unlock()
+	})
+}
+
+// The handlers as main routes them, so their request stats are registered
+var (
+	esHandler        = instrument("es", handleElasticsearch)
+	idbNotifyHandler = instrument("idb", handleIdbNotify)
+	pgiHandler       = instrument("pgi", handlePgiCheckStatus)
+)
+
+// notify sends an IDB notify body to the handler.
+func notify(body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/idb-facade/api/v1/payments/notify", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	idbNotifyHandler(rec, req)
+	return rec
+}
+
+func TestIdbCacheKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		gateway    string
+		paymentIds []string
+		want       string
+	}{
+		{"single", "adyen", []string{"pay_a"}, "adyen:pay_a"},
+		{"sorted", "adyen", []string{"pay_a", "pay_b"}, "adyen:pay_a,pay_b"},
+		{"reordered", "adyen", []string{"pay_b", "pay_a"}, "adyen:pay_a,pay_b"},
+		{"duplicated", "adyen", []string{"pay_a", "pay_a", "pay_b"}, "adyen:pay_a,pay_b"},
+		{"duplicated out of order", "adyen", []string{"pay_b", "pay_a", "pay_b"}, "adyen:pay_a,pay_b"},
+		{"other gateway", "stripe", []string{"pay_b", "pay_a"}, "stripe:pay_a,pay_b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := idbCacheKey(tt.gateway, tt.paymentIds); got != tt.want {
+				t.Errorf("idbCacheKey(%q, %v) = %q, want %q", tt.gateway, tt.paymentIds, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdbCacheKeyIgnoresOrder(t *testing.T) {
+	resetCaches(t)
+	quietUpstreams(t)
+
+	for _, body := range []string{
+		`{"gatewayName":"adyen","paymentIds":["pay_a","pay_b"]}`,
+		`{"gatewayName":"adyen","paymentIds":["pay_b","pay_a"]}`,
+	} {
+		if rec := notify(body, nil); rec.Code != http.StatusOK {
+			t.Fatalf("notify %s: status %d, body %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	idbCacheMutex.RLock()
+	defer idbCacheMutex.RUnlock()
+	if keys := idbSuccessSet.Keys(); len(keys) != 1 || keys[0] != "adyen:pay_a,pay_b" {
+		t.Errorf("idbSuccessSet keys = %v, want [adyen:pay_a,pay_b]", keys)
+	}
+}
+
+func TestIdbNotifyDuplicateIds(t *testing.T) {
+	resetCaches(t)
+	quietUpstreams(t)
+	body := `{"gatewayName":"adyen","paymentIds":["pay_a","pay_b","pay_a","pay_c","pay_b","pay_a"]}`
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+		wantDetail string
+	}{
+		{"rejected", nil, http.StatusBadRequest, "Duplicate paymentIds in batch: pay_a, pay_b"},
+		{"header false", map[string]string{"X-Allow-Duplicates": "false"}, http.StatusBadRequest, "Duplicate paymentIds in batch: pay_a, pay_b"},
+		{"allowed", map[string]string{"X-Allow-Duplicates": "true"}, http.StatusOK, ""},
+		{"allowed any case", map[string]string{"X-Allow-Duplicates": "TRUE"}, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := notify(body, tt.header)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d; body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantDetail == "" {
+				return
+			}
+			var problem struct {
+				Code   string `json:"code"`
+				Detail string `json:"detail"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("decoding %s: %v", rec.Body, err)
+			}
+			if problem.Code != codeInvalidRequest || problem.Detail != tt.wantDetail {
+				t.Errorf("got %s %q, want %s %q", problem.Code, problem.Detail, codeInvalidRequest, tt.wantDetail)
+			}
+		})
+	}
+}
+
+// BenchmarkMixedTraffic spreads parallel requests evenly over ES, IDB and PGI
+// for a working set of payments, so lookups of one kind run alongside writes
+// of the others. "handlers" runs the real handlers. The cache-locks pair runs
+// the same mix of cache lookups and writes, each next to the config read its
+// handler does under cacheMutex, once with every cache behind cacheMutex as
+// before the caches got their own locks and once with the per-cache locks.
+// Contention only shows with several CPUs, e.g. -cpu=1,4,8.
+func BenchmarkMixedTraffic(b *testing.B) {
+	b.Run("handlers", benchmarkMixedHandlers)
+	b.Run("cache-locks/single", func(b *testing.B) {
+		benchmarkMixedCacheLocks(b, &cacheMutex, &cacheMutex, &cacheMutex)
+	})
+	b.Run("cache-locks/sharded", func(b *testing.B) {
+		benchmarkMixedCacheLocks(b, &gatewayCacheMutex, &idbCacheMutex, &pgiCacheMutex)
+	})
+}
+
+// benchmarkPayments returns n payment IDs with the gateways they hash to.
+func benchmarkPayments(n int) (ids, assigned []string) {
+	ids = make([]string, n)
+	assigned = make([]string, n)
+	for i := range ids {
+		ids[i] = "pay_bench_" + strconv.Itoa(i)
+		assigned[i] = determineGateway(ids[i])
+	}
+	return ids, assigned
+}
+
+func benchmarkMixedHandlers(b *testing.B) {
+	resetCaches(b)
+	quietUpstreams(b)
+
+	const payments = 1024
+	ids, assigned := benchmarkPayments(payments)
+
+	var next atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := next.Add(1)
+			i := n % payments
+			rec := httptest.NewRecorder()
+			switch n % 3 {
+			case 0:
+				req := httptest.NewRequest(http.MethodGet, "/elasticsearch/payments/_doc/"+ids[i], nil)
+				req.SetPathValue("paymentId", ids[i])
+				esHandler(rec, req)
+			case 1:
+				body := `{"gatewayName":"` + assigned[i] + `","paymentIds":["` + ids[i] + `"]}`
+				req := httptest.NewRequest(http.MethodPost, "/idb-facade/api/v1/payments/notify", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				idbNotifyHandler(rec, req)
+			default:
+				req := httptest.NewRequest(http.MethodPost, "/pgi-gateway/api/v1/payments/"+ids[i]+"/check-status", nil)
+				req.SetPathValue("paymentId", ids[i])
+				req.Header.Set("X-Gateway-Name", assigned[i])
+				pgiHandler(rec, req)
+			}
+			if rec.Code >= http.StatusMultipleChoices {
+				b.Errorf("status %d: %s", rec.Code, rec.Body)
+				return
+			}
+		}
+	})
+}
+
+// benchmarkMixedCacheLocks does what the handlers do on the success caches,
+// with the gateway, IDB and PGI caches guarded by the given locks.
+func benchmarkMixedCacheLocks(b *testing.B, gatewayLock, idbLock, pgiLock *sync.RWMutex) {
+	resetCaches(b)
+
+	const payments = 1024
+	ids, assigned := benchmarkPayments(payments)
+
+	var next atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := next.Add(1)
+			i := n % payments
+			currentErrorRates()
+			switch n % 3 {
+			case 0:
+				gatewayLock.Lock()
+				if _, exists := gatewayCache.Get(ids[i]); !exists {
+					gatewayCache.Put(ids[i], gatewayEntry{Gateway: assigned[i], CachedAt: clockNow()})
+				}
+				gatewayLock.Unlock()
+			case 1:
+				key := idbCacheKey(assigned[i], ids[i:i+1])
+				idbLock.Lock()
+				if _, exists := idbSuccessSet.Get(key); !exists {
+					idbSuccessSet.Put(key, struct{}{})
+				}
+				idbLock.Unlock()
+			default:
+				pgiLock.Lock()
+				if _, exists := pgiSuccessSet.Get(ids[i]); !exists {
+					pgiSuccessSet.Put(ids[i], struct{}{})
+				}
+				pgiLock.Unlock()
+			}
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// Maintenance mode (adjustable via /admin/maintenance): every business
+// endpoint answers 503 while admin, health and metrics stay up. Checked on
+// every request, so it's an atomic rather than behind cacheMutex.
+var maintenanceMode atomic.Bool
+
+func writeMaintenance(w http.ResponseWriter) {
+	writeProblemWith(w, http.StatusServiceUnavailable, codeMaintenance, "Service Unavailable", "Service is down for maintenance",
+		map[string]any{"maintenance": true})
+}
+
+func handleGetMaintenance(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"enabled": maintenanceMode.Load()})
+}
+
+// handleSetMaintenance switches maintenance mode, e.g. {"enabled":true}.
+// Requests already running finish normally.
+func handleSetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.Enabled == nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "enabled is required")
+		return
+	}
+
+	maintenanceMode.Store(*req.Enabled)
+
+	if *req.Enabled {
+		logger.Warn("Maintenance mode enabled", "endpoint", "admin")
+	} else {
+		logger.Info("Maintenance mode disabled", "endpoint", "admin")
+	}
+
+	handleGetMaintenance(w, r)
+}
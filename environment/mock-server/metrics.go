@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mock_requests_total",
+		Help: "Requests handled per endpoint and response status code.",
+	}, []string{"endpoint", "code"})
+
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mock_errors_total",
+		Help: "Error responses per endpoint, split by random injection vs X-Force-Error.",
+	}, []string{"endpoint", "type"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mock_request_duration_seconds",
+		Help:    "Request duration per endpoint, including simulated latency.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"endpoint"})
+
+	cacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mock_cache_lookups_total",
+		Help: "Success-cache lookups per cache, split by hit and miss.",
+	}, []string{"cache", "result"})
+)
+
+// Error types for mock_errors_total
+const (
+	errorInjected = "injected"
+	errorForced   = "forced"
+)
+
+func recordCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookupsTotal.WithLabelValues(cache, result).Inc()
+	if hit {
+		cacheLookups[cache].hits.Add(1)
+		requestStats[cacheEndpoints[cache]].cacheHits.Add(1)
+	} else {
+		cacheLookups[cache].misses.Add(1)
+	}
+}
+
+func recordError(endpoint, errorType string) {
+	errorsTotal.WithLabelValues(endpoint, errorType).Inc()
+	if errorType == errorForced {
+		requestStats[endpoint].forcedErrors.Add(1)
+	} else {
+		requestStats[endpoint].injectedErrors.Add(1)
+	}
+}
+
+// statusRecorder captures the status code written by a handler, and whether
+// the response was an injected or forced error (for the request's span).
+type statusRecorder struct {
+	http.ResponseWriter
+	status        int
+	errorInjected bool
+}
+
+// markErrorInjected flags the response as a simulated failure.
+func markErrorInjected(w http.ResponseWriter) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.errorInjected = true
+	}
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to hijack).
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// instrument wraps a handler with request counting and duration observation
+// under the given endpoint label. It also enforces maintenance mode and the
+// MAX_INFLIGHT cap, so every business endpoint shares one concurrency budget.
+func instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	stats := registerEndpointStats(endpoint)
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		stats.requests.Add(1)
+		countRegion(r)
+
+		r, trace := startTrace(r)
+		if trace != nil {
+			trace.setHeaders(rec.Header())
+		}
+
+		switch {
+		case maintenanceMode.Load():
+			writeMaintenance(rec)
+		case acquireSlot():
+			defer releaseSlot()
+			next(rec, r)
+		default:
+			writeOverCapacity(rec)
+		}
+
+		requestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		requestsTotal.WithLabelValues(endpoint, strconv.Itoa(rec.status)).Inc()
+		if trace != nil && tracingEnabled {
+			endSpan(trace, endpoint, start, rec.status, rec.errorInjected)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Payments Elasticsearch should report as nonexistent (guarded by cacheMutex,
+// adjustable via /admin/missing): any ID starting with missingPrefix (when
+// set) plus the explicitly listed IDs.
+var (
+	missingPrefix string
+	missingIds    = make(map[string]struct{})
+)
+
+// paymentMissing reports whether lookups for paymentId should 404.
+func paymentMissing(paymentId string) bool {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+
+	if missingPrefix != "" && strings.HasPrefix(paymentId, missingPrefix) {
+		return true
+	}
+	_, listed := missingIds[paymentId]
+	return listed
+}
+
+// notFoundDocument is Elasticsearch's body for a get on an absent document.
+func notFoundDocument(paymentId string) map[string]any {
+	return map[string]any{
+		"_index": "payments",
+		"_id":    paymentId,
+		"found":  false,
+	}
+}
+
+func handleGetMissing(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	ids := make([]string, 0, len(missingIds))
+	for id := range missingIds {
+		ids = append(ids, id)
+	}
+	prefix := missingPrefix
+	cacheMutex.RUnlock()
+	slices.Sort(ids)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"prefix": prefix, "ids": ids})
+}
+
+// handleAddMissing marks payments as nonexistent, e.g.
+// {"ids":["pay_gone"],"prefix":"missing_"}. IDs are added to the existing
+// set; prefix replaces the current one ("" disables prefix matching).
+func handleAddMissing(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ids    []string `json:"ids"`
+		Prefix *string  `json:"prefix"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if slices.Contains(req.Ids, "") {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "ids must not contain empty strings")
+		return
+	}
+
+	cacheMutex.Lock()
+	for _, id := range req.Ids {
+		missingIds[id] = struct{}{}
+	}
+	if req.Prefix != nil {
+		missingPrefix = *req.Prefix
+	}
+	cacheMutex.Unlock()
+
+	logger.Info("Missing payments updated", "endpoint", "admin", "added", len(req.Ids), "prefix", req.Prefix)
+
+	handleGetMissing(w, r)
+}
+
+// handleRemoveMissing makes a listed payment findable again.
+func handleRemoveMissing(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+
+	cacheMutex.Lock()
+	_, listed := missingIds[paymentId]
+	delete(missingIds, paymentId)
+	cacheMutex.Unlock()
+
+	if !listed {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "Payment '"+paymentId+"' is not marked missing")
+		return
+	}
+
+	logger.Info("Missing payment removed", "endpoint", "admin", "paymentId", paymentId)
+
+	handleGetMissing(w, r)
+}
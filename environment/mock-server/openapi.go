@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openapiSpec is the hand-maintained OpenAPI 3 contract for every route.
+// Keep openapi.json in sync when adding or changing endpoints.
+//
+//go:embed openapi.json
+var openapiSpec []byte
+
+func handleOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openapiSpec)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"time"
+)
+
+// paymentDetails are the document fields ES returns beyond the gateway.
+// Amounts are in minor units (cents).
+type paymentDetails struct {
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+var (
+	// Details pinned via the seed endpoint; everything else is derived from
+	// the payment ID (guarded by cacheMutex)
+	paymentDetailOverrides = make(map[string]paymentDetails)
+
+	currencies = []string{"USD", "EUR", "GBP"}
+
+	// Derived createdAt values fall within the year after this instant
+	createdAtEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// derivePaymentDetails generates stable details from the payment ID so the
+// same payment always reports the same amount, currency and creation time.
+func derivePaymentDetails(paymentId string) paymentDetails {
+	hash := md5.Sum([]byte("details:" + paymentId))
+	return paymentDetails{
+		Amount:    100 + int64(binary.BigEndian.Uint32(hash[0:4])%99_901), // 1.00 .. 1000.00
+		Currency:  currencies[int(hash[4])%len(currencies)],
+		CreatedAt: createdAtEpoch.Add(time.Duration(binary.BigEndian.Uint32(hash[5:9])%(365*24*3600)) * time.Second),
+	}
+}
+
+// lookupPaymentDetails returns seeded details if any, else derived ones.
+// Caller must hold cacheMutex.
+func lookupPaymentDetails(paymentId string) paymentDetails {
+	if details, ok := paymentDetailOverrides[paymentId]; ok {
+		return details
+	}
+	return derivePaymentDetails(paymentId)
+}
+
+// lookupPaymentStatus returns the payment's lifecycle status, or pending if
+// PGI has never seen it. Caller must hold cacheMutex.
+func lookupPaymentStatus(paymentId string) string {
+	if state, ok := paymentStates[paymentId]; ok {
+		return state.Status
+	}
+	return statusPending
+}
+
+// paymentDocument builds the ES envelope for a payment. The _index/_id shape
+// is what clients depend on; _source carries the payment fields.
+func paymentDocument(paymentId, gateway string) map[string]any {
+	cacheMutex.RLock()
+	details := lookupPaymentDetails(paymentId)
+	status := lookupPaymentStatus(paymentId)
+	cacheMutex.RUnlock()
+
+	return map[string]any{
+		"_index": "payments",
+		"_id":    paymentId,
+		"_source": map[string]any{
+			"paymentId":   paymentId,
+			"gatewayName": gateway,
+			"status":      status,
+			"amount":      details.Amount,
+			"currency":    details.Currency,
+			"createdAt":   details.CreatedAt.UTC().Format(time.RFC3339),
+		},
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Default accepted payment ID shape. Loose enough for the IDs used across the
+// worker and docs (pay_1, pay-123, PAY-001) but rejects whitespace, percent
+// escapes and other URL junk.
+const defaultPaymentIdPattern = `^[A-Za-z0-9_-]{1,128}$`
+
+var (
+	// Read on every business request, so it gets its own lock instead of cacheMutex
+	paymentIdMutex   sync.RWMutex
+	paymentIdPattern = regexp.MustCompile(defaultPaymentIdPattern)
+)
+
+func currentPaymentIdPattern() *regexp.Regexp {
+	paymentIdMutex.RLock()
+	defer paymentIdMutex.RUnlock()
+	return paymentIdPattern
+}
+
+// validatePaymentId rejects IDs that don't match the configured pattern with
+// a 400 naming the ID and the pattern. It reports whether the ID is valid.
+func validatePaymentId(w http.ResponseWriter, paymentId string) bool {
+	pattern := currentPaymentIdPattern()
+	if pattern.MatchString(paymentId) {
+		return true
+	}
+	writeProblem(w, http.StatusBadRequest, codeInvalidPaymentId, "Bad Request", "Invalid payment ID "+strconv.Quote(paymentId)+": must match "+pattern.String())
+	return false
+}
+
+func handleGetPaymentIdPattern(w http.ResponseWriter, _ *http.Request) {
+	paymentIdMutex.RLock()
+	pattern := paymentIdPattern.String()
+	paymentIdMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"pattern": pattern})
+}
+
+// handleSetPaymentIdPattern replaces the payment ID pattern, e.g.
+// {"pattern":"^pay_[A-Za-z0-9]+$"}. An empty pattern restores the default.
+func handleSetPaymentIdPattern(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pattern string `json:"pattern"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.Pattern == "" {
+		req.Pattern = defaultPaymentIdPattern
+	}
+	pattern, err := regexp.Compile(req.Pattern)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid pattern: "+err.Error())
+		return
+	}
+
+	paymentIdMutex.Lock()
+	paymentIdPattern = pattern
+	paymentIdMutex.Unlock()
+
+	logger.Info("Payment ID pattern updated", "endpoint", "admin", "pattern", req.Pattern)
+
+	handleGetPaymentIdPattern(w, r)
+}
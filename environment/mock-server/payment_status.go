@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Payment lifecycle: pending -> processing -> succeeded | failed. With manual
+// capture enabled, success stops at authorized until the capture endpoint
+// moves it to captured. A dispute moves a succeeded or captured payment to
+// disputed until it is resolved (see disputes.go).
+const (
+	statusPending     = "pending"
+	statusProcessing  = "processing"
+	statusSucceeded   = "succeeded"
+	statusFailed      = "failed"
+	statusAuthorized  = "authorized"
+	statusCaptured    = "captured"
+	statusDisputed    = "disputed"
+	statusChargedBack = "charged_back"
+)
+
+var paymentStatuses = []string{
+	statusPending, statusProcessing, statusSucceeded, statusFailed, statusAuthorized, statusCaptured,
+	statusDisputed, statusChargedBack,
+}
+
+// paymentState is a payment's position in the lifecycle.
+type paymentState struct {
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Polls     int       `json:"polls"` // check-status calls so far
+}
+
+// settled reports whether polling can no longer move the payment. Authorized
+// payments wait for an explicit capture, disputed ones for a resolution.
+func (s paymentState) settled() bool {
+	switch s.Status {
+	case statusSucceeded, statusFailed, statusAuthorized, statusCaptured, statusDisputed, statusChargedBack:
+		return true
+	}
+	return false
+}
+
+// terminal reports whether the payment has reached a final state. Unlike
+// settled, authorized and disputed aren't final since a capture or a dispute
+// resolution can still follow.
+func (s paymentState) terminal() bool {
+	return s.settled() && s.Status != statusAuthorized && s.Status != statusDisputed
+}
+
+var (
+	// Lifecycle state per payment, created on the first PGI poll (guarded by cacheMutex)
+	paymentStates = make(map[string]*paymentState)
+
+	// With a dwell time, a payment advances one step each time it has spent
+	// that long in its current state; with 0 it advances on polls (see
+	// status_polls.go)
+	statusDwell time.Duration
+
+	// Probability that processing ends in failed rather than succeeded
+	statusFailureRate = 0.1
+
+	// Successful payments stop at authorized and must be captured
+	manualCapture bool
+)
+
+// advance moves the payment one step along the lifecycle. Settled states
+// stay put.
+func (s *paymentState) advance(at time.Time) {
+	switch s.Status {
+	case statusPending:
+		s.Status = statusProcessing
+	case statusProcessing:
+		switch {
+		case rollFailure(statusFailureRate):
+			s.Status = statusFailed
+		case manualCapture:
+			s.Status = statusAuthorized
+		default:
+			s.Status = statusSucceeded
+		}
+	default:
+		return
+	}
+	s.UpdatedAt = at
+}
+
+// pollPaymentStatus records a PGI poll and returns the payment's state
+// afterwards. The first poll creates the payment in pending (or settles it
+// right away with a poll threshold of 1).
+func pollPaymentStatus(paymentId string, now time.Time) paymentState {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	state, ok := paymentStates[paymentId]
+	if !ok {
+		state = &paymentState{Status: statusPending, UpdatedAt: now}
+		paymentStates[paymentId] = state
+	}
+	state.Polls++
+
+	switch {
+	case statusDwell > 0:
+		state.advanceByDwell(now)
+	case ok || pollThreshold(paymentId) <= 1:
+		state.advanceByPolls(now, pollThreshold(paymentId))
+	}
+	return *state
+}
+
+// advanceByDwell applies every step whose dwell time has elapsed by now.
+// Caller must hold cacheMutex.
+func (s *paymentState) advanceByDwell(now time.Time) {
+	for !s.settled() && now.Sub(s.UpdatedAt) >= statusDwell {
+		s.advance(s.UpdatedAt.Add(statusDwell))
+	}
+}
+
+// observePaymentStatus returns the payment's state without counting as a
+// poll: elapsed dwell steps are applied, but with no dwell time the state
+// only moves on polls and captures. Unknown payments start in pending, as on
+// a first poll.
+func observePaymentStatus(paymentId string, now time.Time) paymentState {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	state, ok := paymentStates[paymentId]
+	if !ok {
+		state = &paymentState{Status: statusPending, UpdatedAt: now}
+		paymentStates[paymentId] = state
+	} else if statusDwell > 0 {
+		state.advanceByDwell(now)
+	}
+	return *state
+}
+
+type paymentStatusConfig struct {
+	DwellMs         int64   `json:"dwellMs"`
+	FailureRate     float64 `json:"failureRate"`
+	ManualCapture   bool    `json:"manualCapture"`
+	PollsToTerminal int     `json:"pollsToTerminal"`
+}
+
+func handleGetPaymentStatusConfig(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	config := paymentStatusConfig{
+		DwellMs:         statusDwell.Milliseconds(),
+		FailureRate:     statusFailureRate,
+		ManualCapture:   manualCapture,
+		PollsToTerminal: pollsToTerminal,
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetPaymentStatusConfig configures lifecycle progression, e.g.
+// {"dwellMs":2000,"failureRate":0.25,"manualCapture":true}. With dwellMs 0
+// payments settle on their pollsToTerminal-th poll (default 3).
+func handleSetPaymentStatusConfig(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DwellMs         *int64   `json:"dwellMs"`
+		FailureRate     *float64 `json:"failureRate"`
+		ManualCapture   *bool    `json:"manualCapture"`
+		PollsToTerminal *int     `json:"pollsToTerminal"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.DwellMs != nil && *req.DwellMs < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "dwellMs must not be negative")
+		return
+	}
+	if req.FailureRate != nil && (*req.FailureRate < 0 || *req.FailureRate > 1) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "failureRate must be between 0 and 1")
+		return
+	}
+	if req.PollsToTerminal != nil && *req.PollsToTerminal < 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "pollsToTerminal must be at least 1")
+		return
+	}
+
+	cacheMutex.Lock()
+	if req.DwellMs != nil {
+		statusDwell = time.Duration(*req.DwellMs) * time.Millisecond
+	}
+	if req.FailureRate != nil {
+		statusFailureRate = *req.FailureRate
+	}
+	if req.ManualCapture != nil {
+		manualCapture = *req.ManualCapture
+	}
+	if req.PollsToTerminal != nil {
+		pollsToTerminal = *req.PollsToTerminal
+	}
+	config := paymentStatusConfig{
+		DwellMs:         statusDwell.Milliseconds(),
+		FailureRate:     statusFailureRate,
+		ManualCapture:   manualCapture,
+		PollsToTerminal: pollsToTerminal,
+	}
+	cacheMutex.Unlock()
+
+	logger.Info("Payment status config updated", "endpoint", "admin",
+		"dwellMs", config.DwellMs, "failureRate", config.FailureRate, "manualCapture", config.ManualCapture,
+		"pollsToTerminal", config.PollsToTerminal)
+
+	handleGetPaymentStatusConfig(w, r)
+}
+
+// handlePgiCapture captures an authorized payment. Anything other than an
+// authorized payment (including one already captured) is a 409. The response
+// reports the settlement under the fee rule of X-Gateway-Name, or of the
+// gateway ES assigned when the header is absent.
+func handlePgiCapture(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+	if !validatePaymentId(w, paymentId) {
+		return
+	}
+	gateway := r.Header.Get("X-Gateway-Name")
+	if gateway == "" {
+		gateway, _ = cachedGateway(requestCaches(r).gateway, paymentId)
+	}
+
+	reqLog := requestLogger(r, "pgi_capture", paymentId, gateway)
+	reqLog.Debug("Capture requested")
+
+	if connectionFault(w, r, reqLog, "pgi_capture") || handleForcedError(w, r, reqLog, "pgi_capture", codePgiInternal) {
+		return
+	}
+
+	now := clockNow()
+	cacheMutex.Lock()
+	state, ok := paymentStates[paymentId]
+	if !ok || state.Status != statusAuthorized {
+		current := "unknown"
+		if ok {
+			current = state.Status
+		}
+		cacheMutex.Unlock()
+		reqLog.Warn("Capture rejected", "paymentStatus", current)
+		writeProblem(w, http.StatusConflict, codeInvalidPaymentState, "Conflict", "Payment '"+paymentId+"' is "+current+", only authorized payments can be captured")
+		return
+	}
+	state.Status = statusCaptured
+	state.UpdatedAt = now
+	cacheMutex.Unlock()
+	paid := settlementFor(paymentId, gateway)
+
+	reqLog.Info("Payment captured")
+
+	simulateLatency(r, "pgi")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"paymentId":     paymentId,
+		"paymentStatus": statusCaptured,
+		"amount":        paid.Amount,
+		"currency":      paid.Currency,
+		"fee":           paid.Fee,
+		"netAmount":     paid.NetAmount,
+		"timestamp":     formatTimestamp(now),
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// How often a stream re-checks the payment's state
+	streamPollInterval = 250 * time.Millisecond
+
+	// How often an idle stream sends a comment so proxies don't time it out
+	streamKeepAlive = 15 * time.Second
+)
+
+// handlePgiStatusStream streams a payment's lifecycle as server-sent events:
+// one "status" event with the current state straight away, then one per
+// change. The stream ends after a terminal status (succeeded, failed or
+// captured) or when the client disconnects. Watching doesn't count as a
+// poll, so with no dwell time configured the state only moves when the
+// payment is polled or captured elsewhere. Streams are exempt from the
+// server timeouts.
+func handlePgiStatusStream(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+	if !validatePaymentId(w, paymentId) {
+		return
+	}
+
+	reqLog := requestLogger(r, "pgi_stream", paymentId, r.Header.Get("X-Gateway-Name"))
+	reqLog.Debug("Status stream opened")
+
+	if connectionFault(w, r, reqLog, "pgi_stream") || handleForcedError(w, r, reqLog, "pgi_stream", codePgiInternal) {
+		return
+	}
+
+	exemptFromTimeouts(w)
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		reqLog.Warn("Streaming unsupported", "err", err)
+		return
+	}
+
+	poll := time.NewTicker(streamPollInterval)
+	defer poll.Stop()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	var last paymentState
+	for {
+		state := observePaymentStatus(paymentId, clockNow())
+		if state.Status != last.Status {
+			last = state
+			if err := writeStatusEvent(w, paymentId, state); err != nil {
+				reqLog.Debug("Status stream write failed", "err", err)
+				return
+			}
+			rc.Flush()
+			keepAlive.Reset(streamKeepAlive)
+			if state.terminal() {
+				reqLog.Debug("Status stream finished", "paymentStatus", state.Status)
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			reqLog.Debug("Client closed status stream")
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			rc.Flush()
+		case <-poll.C:
+		}
+	}
+}
+
+func writeStatusEvent(w http.ResponseWriter, paymentId string, state paymentState) error {
+	data, _ := json.Marshal(map[string]any{
+		"paymentId":     paymentId,
+		"paymentStatus": state.Status,
+		"updatedAt":     formatTimestamp(state.UpdatedAt),
+	})
+	_, err := fmt.Fprintf(w, "event: status\ndata: %s\n\n", data)
+	return err
+}
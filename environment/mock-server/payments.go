@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paymentView is a payment as the /payments endpoints present it.
+type paymentView struct {
+	PaymentId string    `json:"paymentId"`
+	Gateway   string    `json:"gateway"` // empty when no store knows it
+	Status    string    `json:"status"`
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// paymentKnown reports whether the simulator holds any state for paymentId:
+// a live gateway assignment in cache (the tenant's gateway cache), a
+// lifecycle status or pinned details. Caller must hold cacheMutex and
+// gatewayCacheMutex.
+func paymentKnown(cache *lruCache[gatewayEntry], paymentId string, now time.Time) bool {
+	if entry, ok := cache.Peek(paymentId); ok && !entry.expired(now, gatewayCacheTTL) {
+		return true
+	}
+	_, hasState := paymentStates[paymentId]
+	_, hasDetails := paymentDetailOverrides[paymentId]
+	return hasState || hasDetails
+}
+
+// handleCreatePayment creates a payment up front, e.g.
+// {"paymentId":"pay_1","gateway":"adyen","amount":1250,"currency":"EUR","status":"pending"},
+// so tests can arrange state explicitly instead of through a first ES lookup.
+// paymentId and gateway are required; amount and currency default to the
+// values derived from the ID, status to pending and createdAt to now. The
+// payment is then served by ES and advanced by PGI like any other. The
+// gateway goes into the X-Tenant-Id tenant's cache; status and details are
+// shared.
+func handleCreatePayment(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PaymentId string     `json:"paymentId"`
+		Gateway   string     `json:"gateway"`
+		Amount    *int64     `json:"amount"`
+		Currency  string     `json:"currency"`
+		Status    string     `json:"status"`
+		CreatedAt *time.Time `json:"createdAt"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.PaymentId == "" {
+		writeProblem(w, http.StatusBadRequest, codeInvalidPaymentId, "Bad Request", "paymentId is required")
+		return
+	}
+	if !validatePaymentId(w, req.PaymentId) {
+		return
+	}
+	if registered := currentGateways(); !slices.Contains(registered, req.Gateway) {
+		writeProblem(w, http.StatusBadRequest, codeUnknownGateway, "Bad Request",
+			"gateway must be one of "+strings.Join(registered, ", "))
+		return
+	}
+	if req.Status == "" {
+		req.Status = statusPending
+	}
+	if !slices.Contains(paymentStatuses, req.Status) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+			"status must be one of "+strings.Join(paymentStatuses, ", "))
+		return
+	}
+	if req.Amount != nil && *req.Amount < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "amount must not be negative")
+		return
+	}
+	if req.Currency != "" && len(req.Currency) != 3 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "currency must be a 3-letter code")
+		return
+	}
+
+	now := clockNow()
+	details := derivePaymentDetails(req.PaymentId)
+	details.CreatedAt = now.Truncate(time.Second) // ES serves whole seconds
+	if req.Amount != nil {
+		details.Amount = *req.Amount
+	}
+	if req.Currency != "" {
+		details.Currency = strings.ToUpper(req.Currency)
+	}
+	if req.CreatedAt != nil {
+		details.CreatedAt = *req.CreatedAt
+	}
+
+	cache := requestCaches(r).gateway
+	cacheMutex.Lock()
+	gatewayCacheMutex.Lock()
+	if paymentKnown(cache, req.PaymentId, now) {
+		gatewayCacheMutex.Unlock()
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusConflict, codePaymentExists, "Conflict",
+			"Payment "+strconv.Quote(req.PaymentId)+" already exists")
+		return
+	}
+	cache.Put(req.PaymentId, gatewayEntry{Gateway: req.Gateway, CachedAt: now})
+	paymentStates[req.PaymentId] = &paymentState{Status: req.Status, UpdatedAt: now}
+	paymentDetailOverrides[req.PaymentId] = details
+	gatewayCacheMutex.Unlock()
+	cacheMutex.Unlock()
+
+	logger.Info("Payment created", "endpoint", "payments", "paymentId", req.PaymentId,
+		"gateway", req.Gateway, "status", req.Status, "tenant", tenantId(r))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(paymentView{
+		PaymentId: req.PaymentId,
+		Gateway:   req.Gateway,
+		Status:    req.Status,
+		Amount:    details.Amount,
+		Currency:  details.Currency,
+		CreatedAt: details.CreatedAt.UTC(),
+	})
+}
+
+// knownPayments returns the gateway of every payment the simulator holds
+// state for: the tenant's gateway assignments, IDB-notified batches and PGI
+// successes, plus the shared lifecycle statuses and pinned details. The
+// gateway comes from the live gateway assignment, else from an IDB batch
+// naming the payment, and is empty when neither exists. Caller must hold
+// cacheMutex.
+func knownPayments(caches *tenantCaches, now time.Time) map[string]string {
+	rlockCaches()
+	defer runlockCaches()
+
+	// Weakest source first, so a live assignment wins over an IDB batch
+	known := make(map[string]string)
+	addKnown := func(paymentId string) {
+		if _, ok := known[paymentId]; !ok {
+			known[paymentId] = ""
+		}
+	}
+	for _, key := range caches.idb.Keys() {
+		batchGateway, ids, _ := strings.Cut(key, ":")
+		for _, paymentId := range strings.Split(ids, ",") {
+			if paymentId != "" {
+				known[paymentId] = batchGateway
+			}
+		}
+	}
+	for _, paymentId := range caches.pgi.Keys() {
+		addKnown(paymentId)
+	}
+	for paymentId := range paymentStates {
+		addKnown(paymentId)
+	}
+	for paymentId := range paymentDetailOverrides {
+		addKnown(paymentId)
+	}
+	caches.gateway.Range(func(paymentId string, entry gatewayEntry) {
+		if !entry.expired(now, gatewayCacheTTL) {
+			known[paymentId] = entry.Gateway
+		}
+	})
+	return known
+}
+
+// handleListPayments lists every payment the simulator holds state for (see
+// knownPayments), one entry per payment however many stores it appears in.
+// Filter with ?status= and ?gateway=; results are in paymentId order and
+// paged with ?offset= and ?limit=. The caches are the X-Tenant-Id tenant's.
+func handleListPayments(w http.ResponseWriter, r *http.Request) {
+	page, _, err := parsePage(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+		return
+	}
+	query := r.URL.Query()
+	status, gateway := query.Get("status"), query.Get("gateway")
+	if status != "" && !slices.Contains(paymentStatuses, status) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+			"status must be one of "+strings.Join(paymentStatuses, ", "))
+		return
+	}
+
+	caches := requestCaches(r)
+	cacheMutex.RLock()
+	known := knownPayments(caches, clockNow())
+
+	paymentIds := make([]string, 0, len(known))
+	for paymentId, paymentGateway := range known {
+		if gateway != "" && paymentGateway != gateway {
+			continue
+		}
+		if status != "" && lookupPaymentStatus(paymentId) != status {
+			continue
+		}
+		paymentIds = append(paymentIds, paymentId)
+	}
+	total := len(paymentIds)
+	payments := make([]paymentView, 0, min(page.Limit, total))
+	for _, paymentId := range page.apply(paymentIds) {
+		details := lookupPaymentDetails(paymentId)
+		payments = append(payments, paymentView{
+			PaymentId: paymentId,
+			Gateway:   known[paymentId],
+			Status:    lookupPaymentStatus(paymentId),
+			Amount:    details.Amount,
+			Currency:  details.Currency,
+			CreatedAt: details.CreatedAt.UTC(),
+		})
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"total":    total,
+		"offset":   page.Offset,
+		"limit":    page.Limit,
+		"payments": payments,
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Path the success caches are persisted to on shutdown (overridable via CACHE_FILE)
+var cacheFile = "./cache.json"
+
+// cacheSnapshot is the on-disk representation of the three success caches:
+// the default tenant's, with the named tenants' nested under Tenants.
+type cacheSnapshot struct {
+	GatewayCache   map[string]string `json:"gatewayCache"`
+	IdbSuccessKeys []string          `json:"idbSuccessKeys"`
+	PgiSuccessIds  []string          `json:"pgiSuccessIds"`
+
+	Tenants map[string]cacheSnapshot `json:"tenants,omitempty"`
+}
+
+// entries counts the top-level entries, leaving out nested tenants.
+func (s cacheSnapshot) entries() int {
+	return len(s.GatewayCache) + len(s.IdbSuccessKeys) + len(s.PgiSuccessIds)
+}
+
+// snapshotCaches returns every tenant's caches.
+func snapshotCaches() cacheSnapshot {
+	rlockCaches()
+	defer runlockCaches()
+
+	snapshot := snapshotTenant(defaultCaches)
+	if named := namedTenants(); len(named) > 0 {
+		snapshot.Tenants = make(map[string]cacheSnapshot, len(named))
+		for tenant, caches := range named {
+			snapshot.Tenants[tenant] = snapshotTenant(caches)
+		}
+	}
+	return snapshot
+}
+
+// snapshotTenant returns one tenant's caches. Caller must hold the cache
+// locks.
+func snapshotTenant(caches *tenantCaches) cacheSnapshot {
+	return cacheSnapshot{
+		GatewayCache:   gatewayCacheView(caches.gateway),
+		IdbSuccessKeys: caches.idb.Keys(),
+		PgiSuccessIds:  caches.pgi.Keys(),
+	}
+}
+
+// importTenant adds the top-level entries of snapshot to caches as cached at
+// now, skipping empty keys and gateways not in known (any gateway when known
+// is nil). Caller must hold the cache locks.
+func importTenant(caches *tenantCaches, snapshot cacheSnapshot, known []string, now time.Time) (imported, skipped int) {
+	for paymentId, gateway := range snapshot.GatewayCache {
+		if paymentId == "" || (known != nil && !slices.Contains(known, gateway)) {
+			skipped++
+			continue
+		}
+		caches.gateway.Put(paymentId, gatewayEntry{Gateway: gateway, CachedAt: now})
+		imported++
+	}
+	// Keys are saved most recent first, so insert in reverse to keep LRU order
+	for _, set := range []struct {
+		cache *lruCache[struct{}]
+		keys  []string
+	}{{caches.idb, snapshot.IdbSuccessKeys}, {caches.pgi, snapshot.PgiSuccessIds}} {
+		for i := len(set.keys) - 1; i >= 0; i-- {
+			if set.keys[i] == "" {
+				skipped++
+				continue
+			}
+			set.cache.Put(set.keys[i], struct{}{})
+			imported++
+		}
+	}
+	return imported, skipped
+}
+
+// saveCaches writes the caches to path atomically: the JSON goes to a temp
+// file in the same directory which is synced and then renamed over path, so
+// a crash mid-write never leaves a truncated file behind.
+func saveCaches(path string) error {
+	data, err := json.MarshalIndent(snapshotCaches(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cache-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadCaches replaces the in-memory caches with the contents of path. On any
+// error the caches are left untouched.
+func loadCaches(path string) (cacheSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cacheSnapshot{}, err
+	}
+
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return cacheSnapshot{}, err
+	}
+
+	// Restored gateways start a fresh TTL window
+	now := clockNow()
+
+	lockCaches()
+	defer unlockCaches()
+
+	gatewayCache.Clear()
+	idbSuccessSet.Clear()
+	pgiSuccessSet.Clear()
+	importTenant(defaultCaches, snapshot, nil, now)
+
+	tenantMutex.Lock()
+	tenants = make(map[string]*tenantCaches)
+	tenantMutex.Unlock()
+	for tenant, dump := range snapshot.Tenants {
+		if !validNamedTenant(tenant) {
+			log.Printf("WARNING: skipping invalid tenant %q in %s", tenant, path)
+			continue
+		}
+		caches, err := createTenant(tenant)
+		if err != nil {
+			log.Printf("WARNING: skipping tenant %q in %s: %v", tenant, path, err)
+			continue
+		}
+		importTenant(caches, dump, nil, now)
+	}
+
+	return snapshot, nil
+}
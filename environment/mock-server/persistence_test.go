@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// resetCaches empties every tenant's caches before the test and again after
+// it, so tests sharing the package globals don't see each other's entries.
+func resetCaches(t testing.TB) {
+	t.Helper()
+	reset := func() {
+		lockCaches()
+		clearCaches("", scopeAll, false)
+		unlockCaches()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestSaveAndLoadCaches(t *testing.T) {
+	resetCaches(t)
+	now := clockNow()
+
+	lockCaches()
+	gatewayCache.Put("pay_1", gatewayEntry{Gateway: "stripe", CachedAt: now})
+	gatewayCache.Put("pay_2", gatewayEntry{Gateway: "adyen", CachedAt: now})
+	idbSuccessSet.Put("adyen:pay_1,pay_2", struct{}{})
+	idbSuccessSet.Put("stripe:pay_3", struct{}{})
+	pgiSuccessSet.Put("pay_1", struct{}{})
+	pgiSuccessSet.Put("pay_2", struct{}{})
+	unlockCaches()
+
+	team, err := func() (*tenantCaches, error) {
+		lockCaches()
+		defer unlockCaches()
+		return createTenant("team-a")
+	}()
+	if err != nil {
+		t.Fatalf("createTenant: %v", err)
+	}
+	lockCaches()
+	team.gateway.Put("pay_9", gatewayEntry{Gateway: "paypal", CachedAt: now})
+	team.pgi.Put("pay_9", struct{}{})
+	unlockCaches()
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := saveCaches(path); err != nil {
+		t.Fatalf("saveCaches: %v", err)
+	}
+
+	resetCaches(t)
+	if n := gatewayCache.Len() + idbSuccessSet.Len() + pgiSuccessSet.Len(); n != 0 {
+		t.Fatalf("caches hold %d entries after clearing, want 0", n)
+	}
+
+	snapshot, err := loadCaches(path)
+	if err != nil {
+		t.Fatalf("loadCaches: %v", err)
+	}
+	if got := snapshot.entries(); got != 6 {
+		t.Errorf("snapshot.entries() = %d, want 6", got)
+	}
+
+	rlockCaches()
+	defer runlockCaches()
+	for paymentId, want := range map[string]string{"pay_1": "stripe", "pay_2": "adyen"} {
+		if entry, ok := gatewayCache.Peek(paymentId); !ok || entry.Gateway != want {
+			t.Errorf("gatewayCache[%s] = %q, %v; want %q", paymentId, entry.Gateway, ok, want)
+		}
+	}
+	if got, want := idbSuccessSet.Keys(), []string{"stripe:pay_3", "adyen:pay_1,pay_2"}; !slices.Equal(got, want) {
+		t.Errorf("idbSuccessSet keys = %v, want %v", got, want)
+	}
+	if got, want := pgiSuccessSet.Keys(), []string{"pay_2", "pay_1"}; !slices.Equal(got, want) {
+		t.Errorf("pgiSuccessSet keys = %v, want %v", got, want)
+	}
+
+	restored, ok := namedTenants()["team-a"]
+	if !ok {
+		t.Fatal("tenant team-a not restored")
+	}
+	if entry, ok := restored.gateway.Peek("pay_9"); !ok || entry.Gateway != "paypal" {
+		t.Errorf("team-a gateway[pay_9] = %q, %v; want paypal", entry.Gateway, ok)
+	}
+	if !restored.pgi.Contains("pay_9") {
+		t.Error("team-a pgi success for pay_9 not restored")
+	}
+}
+
+func TestLoadCachesBadFile(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`{"gatewayCache": {"pay_1": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"missing", filepath.Join(dir, "missing.json")},
+		{"malformed", malformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetCaches(t)
+
+			// The error is only logged at startup; the server carries on empty
+			if _, err := loadCaches(tt.path); err == nil {
+				t.Error("loadCaches returned no error")
+			}
+
+			rlockCaches()
+			defer runlockCaches()
+			if n := gatewayCache.Len() + idbSuccessSet.Len() + pgiSuccessSet.Len(); n != 0 {
+				t.Errorf("caches hold %d entries, want 0", n)
+			}
+			if n := len(namedTenants()); n != 0 {
+				t.Errorf("%d named tenants, want 0", n)
+			}
+		})
+	}
+}
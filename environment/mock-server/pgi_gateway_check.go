@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// X-Gateway-Name on check-status must name a registered gateway. Set
+// PGI_GATEWAY_VALIDATION=false at startup to accept any value, including
+// none, as older clients may rely on.
+var pgiGatewayValidation = true
+
+// Set via STRICT_GATEWAY_CHECK=true at startup: check-status answers 409 when
+// X-Gateway-Name disagrees with the gateway ES assigned the payment, instead
+// of only logging it.
+var strictGatewayCheck bool
+
+// validatePgiGateway rejects a missing X-Gateway-Name with 400 and one naming
+// an unregistered gateway with 422. It reports whether the request may go on.
+func validatePgiGateway(w http.ResponseWriter, gateway string) bool {
+	if !pgiGatewayValidation {
+		return true
+	}
+	if gateway == "" {
+		writeProblem(w, http.StatusBadRequest, codeMissingGateway, "Bad Request", "X-Gateway-Name header is required")
+		return false
+	}
+	if registered := currentGateways(); !slices.Contains(registered, gateway) {
+		writeProblem(w, http.StatusUnprocessableEntity, codeUnknownGateway, "Unprocessable Entity",
+			"Unknown gateway "+strconv.Quote(gateway)+" in X-Gateway-Name, must be one of "+strings.Join(registered, ", "))
+		return false
+	}
+	return true
+}
+
+// cachedGateway returns the gateway ES assigned paymentId, if cache (a
+// tenant's gateway cache) has a live entry. It peeks so the check doesn't
+// refresh the entry.
+func cachedGateway(cache *lruCache[gatewayEntry], paymentId string) (string, bool) {
+	ttl := currentGatewayCacheTTL()
+	gatewayCacheMutex.RLock()
+	entry, exists := cache.Peek(paymentId)
+	gatewayCacheMutex.RUnlock()
+	if !exists || entry.expired(clockNow(), ttl) {
+		return "", false
+	}
+	return entry.Gateway, true
+}
+
+// checkGatewayMismatch catches a client sending PGI a different gateway than
+// ES handed out for the payment, which points at a routing bug in the client.
+// Payments ES hasn't assigned (or whose entry expired) aren't checked. A
+// mismatch is logged, and with strictGatewayCheck also rejected with a 409
+// carrying both gateways. It reports whether the request may go on.
+func checkGatewayMismatch(w http.ResponseWriter, reqLog *slog.Logger, cache *lruCache[gatewayEntry], paymentId, gateway string) bool {
+	if gateway == "" {
+		return true
+	}
+	cached, ok := cachedGateway(cache, paymentId)
+	if !ok || cached == gateway {
+		return true
+	}
+
+	if !strictGatewayCheck {
+		reqLog.Warn("X-Gateway-Name does not match the gateway ES assigned", "cachedGateway", cached)
+		return true
+	}
+	reqLog.Error("GATEWAY MISMATCH: X-Gateway-Name does not match the gateway ES assigned, rejecting",
+		"cachedGateway", cached)
+	writeProblemWith(w, http.StatusConflict, codeGatewayMismatch, "Conflict",
+		"X-Gateway-Name "+strconv.Quote(gateway)+" does not match gateway "+strconv.Quote(cached)+" assigned by Elasticsearch",
+		map[string]any{"headerGateway": gateway, "cachedGateway": cached})
+	return false
+}
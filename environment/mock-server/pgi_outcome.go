@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// Decisions check-status can report for a payment
+var pgiOutcomeValues = []string{"accepted", "approved", "declined"}
+
+// pgiOutcome is the decision check-status reports for one payment instead of
+// the default "accepted".
+type pgiOutcome struct {
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Outcomes by payment ID (guarded by cacheMutex, adjustable via /admin/pgi-outcome)
+var pgiOutcomes = make(map[string]pgiOutcome)
+
+// lookupPgiOutcome returns the configured outcome for a payment, or the
+// default accepted one.
+func lookupPgiOutcome(paymentId string) pgiOutcome {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	if outcome, ok := pgiOutcomes[paymentId]; ok {
+		return outcome
+	}
+	return pgiOutcome{Outcome: "accepted"}
+}
+
+func handleGetPgiOutcomes(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	outcomes := make(map[string]pgiOutcome, len(pgiOutcomes))
+	for paymentId, outcome := range pgiOutcomes {
+		outcomes[paymentId] = outcome
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(outcomes)
+}
+
+// handleSetPgiOutcome pins the check-status decision for a payment, e.g.
+// {"paymentId":"pay_1","outcome":"declined","reason":"insufficient_funds"}.
+func handleSetPgiOutcome(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PaymentId string `json:"paymentId"`
+		pgiOutcome
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.PaymentId == "" {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "paymentId is required")
+		return
+	}
+	if !slices.Contains(pgiOutcomeValues, req.Outcome) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "outcome must be one of accepted, approved or declined")
+		return
+	}
+
+	cacheMutex.Lock()
+	pgiOutcomes[req.PaymentId] = req.pgiOutcome
+	cacheMutex.Unlock()
+
+	logger.Info("PGI outcome set", "endpoint", "admin", "paymentId", req.PaymentId, "outcome", req.Outcome, "reason", req.Reason)
+
+	handleGetPgiOutcomes(w, r)
+}
+
+// handleDeletePgiOutcome returns a payment to the default outcome.
+func handleDeletePgiOutcome(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+
+	cacheMutex.Lock()
+	_, exists := pgiOutcomes[paymentId]
+	delete(pgiOutcomes, paymentId)
+	cacheMutex.Unlock()
+
+	if !exists {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "No outcome configured for payment '"+paymentId+"'")
+		return
+	}
+
+	logger.Info("PGI outcome removed", "endpoint", "admin", "paymentId", paymentId)
+
+	handleGetPgiOutcomes(w, r)
+}
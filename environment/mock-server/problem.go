@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+)
+
+// Every error response (business and admin alike) is an RFC 7807 problem
+// details body, so clients parse a single shape:
+//
+//	{"type":"about:blank","title":"Bad Request","status":400,"code":"INVALID_REQUEST","detail":"..."}
+//
+// "code" is an extension member from the catalog below, for clients to branch
+// on instead of matching titles or details.
+//
+// Responses that mimic a real upstream's wire format stay as that upstream
+// sends them: an Elasticsearch "found": false document and the per-document
+// errors inside an _mget response.
+const problemContentType = "application/problem+json"
+
+// Error code catalog. These strings are part of the API: add new codes
+// freely, but never change or reuse an existing one.
+const (
+	// Simulated upstream failures, injected at random or via X-Force-Error
+	codeEsInternal  = "ES_INTERNAL"
+	codeIdbInternal = "IDB_INTERNAL"
+	codePgiInternal = "PGI_INTERNAL"
+
+	// Request problems
+	codeInvalidRequest        = "INVALID_REQUEST"
+	codeInvalidPaymentId      = "INVALID_PAYMENT_ID"
+	codeValidationFailed      = "VALIDATION_FAILED"
+	codePayloadTooLarge       = "PAYLOAD_TOO_LARGE"
+	codeDuplicateNotification = "DUPLICATE_NOTIFICATION"
+	codeIdempotencyKeyReused  = "IDEMPOTENCY_KEY_REUSED"
+	codeMissingGateway        = "MISSING_GATEWAY"
+	codeUnknownGateway        = "UNKNOWN_GATEWAY"
+	codeGatewayMismatch       = "GATEWAY_MISMATCH"
+	codeUnauthorized          = "UNAUTHORIZED"
+	codeNotFound              = "NOT_FOUND"
+	codeMethodNotAllowed      = "METHOD_NOT_ALLOWED"
+	codeConflict              = "CONFLICT"
+	codeConfirmationRequired  = "CONFIRMATION_REQUIRED"
+
+	// Payment state (capture, refund and disputes)
+	codePaymentNotFound     = "PAYMENT_NOT_FOUND"
+	codePaymentExists       = "PAYMENT_EXISTS"
+	codeInvalidPaymentState = "INVALID_PAYMENT_STATE"
+	codeAlreadyRefunded     = "ALREADY_REFUNDED"
+	codeRefundExceedsAmount = "REFUND_EXCEEDS_AMOUNT"
+	codeCurrencyMismatch    = "CURRENCY_MISMATCH"
+	codeDisputeNotFound     = "DISPUTE_NOT_FOUND"
+
+	// Load shedding
+	codeRateLimited  = "RATE_LIMITED"
+	codeOverCapacity = "OVER_CAPACITY"
+	codeMaintenance  = "MAINTENANCE"
+)
+
+// Titles of the simulated upstream failures.
+var internalErrorTitles = map[string]string{
+	codeEsInternal:  "Elasticsearch internal error",
+	codeIdbInternal: "IDB Facade internal error",
+	codePgiInternal: "PGI Gateway internal error",
+}
+
+// writeProblem sends a problem details response. code is from the catalog,
+// title summarizes the kind of problem and detail explains this occurrence.
+func writeProblem(w http.ResponseWriter, status int, code, title, detail string) {
+	writeProblemWith(w, status, code, title, detail, nil)
+}
+
+// writeProblemWith is writeProblem with extension members (e.g.
+// "retryAfterMs") added next to the standard ones.
+func writeProblemWith(w http.ResponseWriter, status int, code, title, detail string, extensions map[string]any) {
+	body := make(map[string]any, len(extensions)+5)
+	maps.Copy(body, extensions)
+	body["type"] = "about:blank"
+	body["title"] = title
+	body["status"] = status
+	body["code"] = code
+	body["detail"] = detail
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// withRouteProblems answers requests that match no route with problem
+// details instead of ServeMux's plain-text 404, or 405 (keeping its Allow
+// header) when the path exists for other methods.
+func withRouteProblems(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fallback, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		// Run the mux's own fallback only to learn its status and Allow
+		capture := &statusCapture{header: http.Header{}, status: http.StatusOK}
+		fallback.ServeHTTP(capture, r)
+		if allow := capture.header.Get("Allow"); allow != "" {
+			w.Header().Set("Allow", allow)
+		}
+		code := codeNotFound
+		if capture.status == http.StatusMethodNotAllowed {
+			code = codeMethodNotAllowed
+		}
+		writeProblem(w, capture.status, code, http.StatusText(capture.status), "No route for "+r.Method+" "+r.URL.Path)
+	})
+}
+
+// statusCapture is a ResponseWriter that keeps the status and headers and
+// discards the body.
+type statusCapture struct {
+	header http.Header
+	status int
+}
+
+func (c *statusCapture) Header() http.Header         { return c.header }
+func (c *statusCapture) Write(b []byte) (int, error) { return len(b), nil }
+func (c *statusCapture) WriteHeader(status int)      { c.status = status }
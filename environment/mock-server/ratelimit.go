@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket holds one client's remaining tokens as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	// Per-client token buckets for the PGI endpoint. Buckets are updated on
+	// every request, so they get their own lock instead of cacheMutex.
+	rateLimitMutex   sync.Mutex
+	rateLimitRPS     float64 // tokens refilled per second; 0 disables limiting
+	rateLimitBurst   = 1     // bucket capacity
+	rateLimitBuckets = make(map[string]*tokenBucket)
+)
+
+// takeToken consumes a token from the client's bucket. When the bucket is
+// empty it reports how long until the next token is available.
+func takeToken(client string, now time.Time) (bool, time.Duration) {
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	if rateLimitRPS <= 0 {
+		return true, 0
+	}
+
+	bucket, ok := rateLimitBuckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rateLimitBurst), last: now}
+		rateLimitBuckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(float64(rateLimitBurst), bucket.tokens+now.Sub(bucket.last).Seconds()*rateLimitRPS)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / rateLimitRPS * float64(time.Second))
+	return false, wait
+}
+
+// clientId identifies the caller by X-Client-Id, falling back to remote IP.
+func clientId(r *http.Request) string {
+	if id := r.Header.Get("X-Client-Id"); id != "" {
+		return id
+	}
+	return remoteIp(r)
+}
+
+// rateLimited rejects requests with 429 and a Retry-After header once the
+// caller's token bucket is empty.
+func rateLimited(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientId(r)
+		allowed, wait := takeToken(client, time.Now())
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			logger.Warn("Rate limit exceeded", "endpoint", endpoint, "client", client, "retryAfterSec", retryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeProblem(w, http.StatusTooManyRequests, codeRateLimited, "Too Many Requests", "Rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+type rateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+}
+
+func handleGetRateLimit(w http.ResponseWriter, _ *http.Request) {
+	rateLimitMutex.Lock()
+	config := rateLimitConfig{RequestsPerSecond: rateLimitRPS, Burst: rateLimitBurst}
+	rateLimitMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetRateLimit configures the PGI limiter, e.g.
+// {"requestsPerSecond":5,"burst":10}. requestsPerSecond 0 disables it.
+// Existing buckets are dropped so the new limit applies immediately.
+func handleSetRateLimit(w http.ResponseWriter, r *http.Request) {
+	var req rateLimitConfig
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.RequestsPerSecond < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "requestsPerSecond must not be negative")
+		return
+	}
+	if req.Burst < 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "burst must be at least 1")
+		return
+	}
+
+	rateLimitMutex.Lock()
+	rateLimitRPS = req.RequestsPerSecond
+	rateLimitBurst = req.Burst
+	rateLimitBuckets = make(map[string]*tokenBucket)
+	rateLimitMutex.Unlock()
+
+	logger.Info("Rate limit updated", "endpoint", "admin", "requestsPerSecond", req.RequestsPerSecond, "burst", req.Burst)
+
+	handleGetRateLimit(w, r)
+}
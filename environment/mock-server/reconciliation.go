@@ -0,0 +1,176 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// reconRow aggregates the payments sharing a status and currency. Fee and
+// netAmount are only non-zero for statuses that settle (succeeded and
+// captured); the others are counted but pay nothing out.
+type reconRow struct {
+	Status    string `json:"status"`
+	Currency  string `json:"currency"`
+	Payments  int    `json:"payments"`
+	Amount    int64  `json:"amount"`
+	Fee       int64  `json:"fee"`
+	NetAmount int64  `json:"netAmount"`
+}
+
+// reconTotal is the settlement for one currency.
+type reconTotal struct {
+	Currency  string `json:"currency"`
+	Payments  int    `json:"payments"`
+	Amount    int64  `json:"amount"`
+	Fee       int64  `json:"fee"`
+	NetAmount int64  `json:"netAmount"`
+}
+
+// parseReconTime accepts an RFC 3339 time or a plain date (midnight UTC).
+func parseReconTime(name, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, errors.New(name + " must be an RFC 3339 time or a YYYY-MM-DD date")
+}
+
+// handleReconciliation summarizes the known payments (see knownPayments) the
+// way a daily reconciliation file does: counts per status and, per currency,
+// the settled amount, fees and net settlement. Filter with ?gateway= and by
+// creation time with ?from= (inclusive) and ?to= (exclusive), each an RFC
+// 3339 time or a YYYY-MM-DD date. Both JSON and ?format=csv carry one row per
+// status and currency. ?tenant= reconciles that tenant's caches.
+func handleReconciliation(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "format must be json or csv")
+		return
+	}
+	from, err := parseReconTime("from", query.Get("from"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+		return
+	}
+	to, err := parseReconTime("to", query.Get("to"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+		return
+	}
+	if from != nil && to != nil && !to.After(*from) {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "to must be after from")
+		return
+	}
+
+	caches, ok := adminTenant(w, r, false)
+	if !ok {
+		return
+	}
+
+	writeReconciliation(w, caches, query.Get("gateway"), from, to, format)
+}
+
+func writeReconciliation(w http.ResponseWriter, caches *tenantCaches, gateway string, from, to *time.Time, format string) {
+	type rowKey struct{ status, currency string }
+	groups := make(map[rowKey]*reconRow)
+	byStatus := make(map[string]int, len(paymentStatuses))
+	for _, status := range paymentStatuses {
+		byStatus[status] = 0
+	}
+	count := 0
+
+	cacheMutex.RLock()
+	for paymentId, paymentGateway := range knownPayments(caches, clockNow()) {
+		if gateway != "" && paymentGateway != gateway {
+			continue
+		}
+		details := lookupPaymentDetails(paymentId)
+		if (from != nil && details.CreatedAt.Before(*from)) || (to != nil && !details.CreatedAt.Before(*to)) {
+			continue
+		}
+		status := lookupPaymentStatus(paymentId)
+		count++
+		byStatus[status]++
+
+		key := rowKey{status, details.Currency}
+		row, ok := groups[key]
+		if !ok {
+			row = &reconRow{Status: status, Currency: details.Currency}
+			groups[key] = row
+		}
+		row.Payments++
+		row.Amount += details.Amount
+		if status == statusSucceeded || status == statusCaptured {
+			paid := settle(details, paymentGateway)
+			row.Fee += paid.Fee
+			row.NetAmount += paid.NetAmount
+		}
+	}
+	cacheMutex.RUnlock()
+
+	rows := make([]reconRow, 0, len(groups))
+	for _, row := range groups {
+		rows = append(rows, *row)
+	}
+	slices.SortFunc(rows, func(a, b reconRow) int {
+		return cmp.Or(cmp.Compare(a.Status, b.Status), cmp.Compare(a.Currency, b.Currency))
+	})
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="reconciliation.csv"`)
+		out := csv.NewWriter(w)
+		out.Write([]string{"status", "currency", "payments", "amount", "fee", "net_amount"})
+		for _, row := range rows {
+			out.Write([]string{
+				row.Status, row.Currency, strconv.Itoa(row.Payments),
+				strconv.FormatInt(row.Amount, 10), strconv.FormatInt(row.Fee, 10), strconv.FormatInt(row.NetAmount, 10),
+			})
+		}
+		out.Flush()
+		return
+	}
+
+	totals := make(map[string]*reconTotal)
+	for _, row := range rows {
+		if row.Status != statusSucceeded && row.Status != statusCaptured {
+			continue
+		}
+		total, ok := totals[row.Currency]
+		if !ok {
+			total = &reconTotal{Currency: row.Currency}
+			totals[row.Currency] = total
+		}
+		total.Payments += row.Payments
+		total.Amount += row.Amount
+		total.Fee += row.Fee
+		total.NetAmount += row.NetAmount
+	}
+	settlements := make([]reconTotal, 0, len(totals))
+	for _, total := range totals {
+		settlements = append(settlements, *total)
+	}
+	slices.SortFunc(settlements, func(a, b reconTotal) int { return cmp.Compare(a.Currency, b.Currency) })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"gateway":    gateway,
+		"from":       from,
+		"to":         to,
+		"payments":   count,
+		"byStatus":   byStatus,
+		"settlement": settlements,
+		"rows":       rows,
+	})
+}
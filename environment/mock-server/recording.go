@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// recordedRequest is one line of the RECORD_FILE: a request as it arrived and
+// the status it got. Status 0 means no response was written (the connection
+// was reset, or the client gave up on a hang).
+type recordedRequest struct {
+	Time          time.Time   `json:"time"`
+	RequestId     string      `json:"requestId"`
+	Method        string      `json:"method"`
+	Path          string      `json:"path"` // with the query string
+	Headers       http.Header `json:"headers"`
+	Body          string      `json:"body,omitempty"`
+	BodyBase64    []byte      `json:"bodyBase64,omitempty"` // instead of body when it isn't UTF-8
+	BodyTruncated bool        `json:"bodyTruncated,omitempty"`
+	Status        int         `json:"status"`
+	DurationMs    int64       `json:"durationMs"`
+}
+
+const (
+	// Bodies beyond this are recorded cut short (bodyTruncated)
+	maxRecordedBody = 1 << 20
+
+	// Records waiting for the writer; when full, new records are dropped
+	// rather than holding up requests
+	recordQueueSize = 4096
+
+	recordFlushInterval = time.Second
+)
+
+// Credentials are never written to the recording
+var redactedHeaders = []string{"Authorization", "X-Admin-Key"}
+
+var (
+	// Set via RECORD_FILE at startup; recording is off when empty
+	recordFile string
+
+	recordQueue   chan recordedRequest
+	recordDone    chan struct{}
+	recordDropped atomic.Int64
+)
+
+// startRecording opens path for appending and starts the writer goroutine.
+func startRecording(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	recordQueue = make(chan recordedRequest, recordQueueSize)
+	recordDone = make(chan struct{})
+	go writeRecords(f)
+	return nil
+}
+
+// writeRecords drains recordQueue into f through a buffer, flushing whenever
+// the queue runs dry and at least every recordFlushInterval.
+func writeRecords(f *os.File) {
+	defer close(recordDone)
+	buf := bufio.NewWriter(f)
+	enc := json.NewEncoder(buf)
+	flush := time.NewTicker(recordFlushInterval)
+	defer flush.Stop()
+
+	for {
+		select {
+		case rec, ok := <-recordQueue:
+			if !ok {
+				if err := buf.Flush(); err != nil {
+					log.Printf("WARNING: failed to write recording: %v", err)
+				}
+				f.Close()
+				return
+			}
+			if err := enc.Encode(rec); err != nil {
+				log.Printf("WARNING: failed to write recording: %v", err)
+			}
+			if len(recordQueue) == 0 {
+				buf.Flush()
+			}
+		case <-flush.C:
+			buf.Flush()
+		}
+	}
+}
+
+// stopRecording writes out what's queued and closes the file. Call it once
+// the server has stopped handling requests.
+func stopRecording() {
+	if recordQueue == nil {
+		return
+	}
+	close(recordQueue)
+	<-recordDone
+	if dropped := recordDropped.Load(); dropped > 0 {
+		log.Printf("WARNING: recording dropped %d request(s) because the writer fell behind", dropped)
+	}
+	log.Printf("Recording written to %s", recordFile)
+}
+
+// withRecording appends every request (except health probes and metrics
+// scrapes) and its response status to the recording when RECORD_FILE is set.
+// The handler sees the body unchanged; the recorded copy is capped at
+// maxRecordedBody.
+func withRecording(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if recordQueue == nil || r.URL.Path == "/metrics" || strings.HasPrefix(r.URL.Path, "/health") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		var body []byte
+		truncated := false
+		if r.Body != nil && r.Body != http.NoBody {
+			prefix, _ := io.ReadAll(io.LimitReader(r.Body, maxRecordedBody+1))
+			truncated = len(prefix) > maxRecordedBody
+			body = prefix[:min(len(prefix), maxRecordedBody)]
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+		}
+
+		rec := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		headers := r.Header.Clone()
+		for _, name := range redactedHeaders {
+			if headers.Get(name) != "" {
+				headers.Set(name, "REDACTED")
+			}
+		}
+		entry := recordedRequest{
+			Time:          start.UTC(),
+			RequestId:     requestId(r),
+			Method:        r.Method,
+			Path:          r.URL.RequestURI(),
+			Headers:       headers,
+			BodyTruncated: truncated,
+			Status:        rec.status,
+			DurationMs:    time.Since(start).Milliseconds(),
+		}
+		if utf8.Valid(body) {
+			entry.Body = string(body)
+		} else {
+			entry.BodyBase64 = body
+		}
+
+		select {
+		case recordQueue <- entry:
+		default:
+			recordDropped.Add(1)
+		}
+	})
+}
+
+// readCloser reads from the reassembled body but closes the original.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// recordingWriter notes the status the handler sends. It stays 0 when the
+// handler writes nothing, e.g. after hijacking the connection.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer (e.g. to
+// flush a stream or hijack the connection).
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// Cumulative refunded amount per payment, in minor units (guarded by cacheMutex)
+	paymentRefunds = make(map[string]int64)
+
+	// Sequence for generated refund IDs (guarded by cacheMutex)
+	refundSeq int64
+)
+
+// handlePgiRefund refunds part or all of a payment that has a successful PGI
+// check and has succeeded or been captured, e.g.
+// {"amount":1000,"currency":"USD"}. Refunds accumulate and can never exceed
+// the original amount.
+func handlePgiRefund(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+	if !validatePaymentId(w, paymentId) {
+		return
+	}
+
+	var req struct {
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.Amount <= 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "amount must be positive")
+		return
+	}
+
+	reqLog := requestLogger(r, "pgi_refund", paymentId, "")
+	reqLog.Debug("Refund requested", "amount", req.Amount, "currency", req.Currency)
+
+	if connectionFault(w, r, reqLog, "pgi_refund") || handleForcedError(w, r, reqLog, "pgi_refund", codePgiInternal) {
+		return
+	}
+
+	cacheMutex.Lock()
+	pgiCacheMutex.RLock()
+	known := requestCaches(r).pgi.Contains(paymentId)
+	pgiCacheMutex.RUnlock()
+	if !known {
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusNotFound, codePaymentNotFound, "Not Found", "Payment '"+paymentId+"' not found")
+		return
+	}
+	if status := lookupPaymentStatus(paymentId); status != statusSucceeded && status != statusCaptured {
+		cacheMutex.Unlock()
+		reqLog.Warn("Refund rejected", "paymentStatus", status)
+		writeProblem(w, http.StatusConflict, codeInvalidPaymentState, "Conflict",
+			"Payment '"+paymentId+"' is "+status+", only succeeded or captured payments can be refunded")
+		return
+	}
+
+	details := lookupPaymentDetails(paymentId)
+	refunded := paymentRefunds[paymentId]
+	remaining := details.Amount - refunded
+
+	switch {
+	case req.Currency != "" && !strings.EqualFold(req.Currency, details.Currency):
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusUnprocessableEntity, codeCurrencyMismatch, "Unprocessable Entity", fmt.Sprintf("Currency %s does not match payment currency %s", req.Currency, details.Currency))
+		return
+	case remaining <= 0:
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusConflict, codeAlreadyRefunded, "Conflict", "Payment '"+paymentId+"' is already fully refunded")
+		return
+	case req.Amount > remaining:
+		cacheMutex.Unlock()
+		writeProblem(w, http.StatusUnprocessableEntity, codeRefundExceedsAmount, "Unprocessable Entity", fmt.Sprintf("Refund of %d exceeds refundable amount %d", req.Amount, remaining))
+		return
+	}
+
+	refunded += req.Amount
+	paymentRefunds[paymentId] = refunded
+	refundSeq++
+	refundId := fmt.Sprintf("re_%d", refundSeq)
+	cacheMutex.Unlock()
+
+	status := "partially_refunded"
+	if refunded == details.Amount {
+		status = "refunded"
+	}
+	reqLog.Info("Refund accepted", "refundId", refundId, "amount", req.Amount, "refundedTotal", refunded)
+
+	simulateLatency(r, "pgi")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"refundId":       refundId,
+		"paymentId":      paymentId,
+		"status":         status,
+		"amount":         req.Amount,
+		"currency":       details.Currency,
+		"refundedTotal":  refunded,
+		"remainingTotal": details.Amount - refunded,
+		"timestamp":      formatTimestamp(clockNow()),
+	})
+}
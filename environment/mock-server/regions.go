@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Requests name the region they are routed to in X-Region. A region with its
+// own latency uses it for every endpoint instead of the endpoint's default;
+// requests without the header or for another region get the default.
+const (
+	regionNone  = "none"  // no X-Region header
+	regionOther = "other" // counted once maxTrackedRegions is reached
+
+	maxTrackedRegions = 64
+)
+
+var regionNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+var (
+	// Latency per region (guarded by cacheMutex, adjustable via
+	// /admin/latency/regions)
+	regionLatency = make(map[string]latencySpec)
+
+	// Business requests per region. Regions are counted as they appear, up
+	// to maxTrackedRegions; later ones share regionOther
+	regionMutex    sync.Mutex
+	regionRequests = make(map[string]int64)
+)
+
+// requestRegion returns the request's X-Region, lowercased, or "" when
+// absent.
+func requestRegion(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.Header.Get("X-Region")))
+}
+
+// countRegion records a business request against its region.
+func countRegion(r *http.Request) {
+	region := requestRegion(r)
+	regionMutex.Lock()
+	defer regionMutex.Unlock()
+	if region == "" {
+		region = regionNone
+	} else if _, tracked := regionRequests[region]; !tracked &&
+		(!regionNamePattern.MatchString(region) || len(regionRequests) >= maxTrackedRegions) {
+		region = regionOther
+	}
+	regionRequests[region]++
+}
+
+func snapshotRegionRequests() map[string]int64 {
+	regionMutex.Lock()
+	defer regionMutex.Unlock()
+	result := make(map[string]int64, len(regionRequests))
+	for region, count := range regionRequests {
+		result[region] = count
+	}
+	return result
+}
+
+func resetRegionRequests() {
+	regionMutex.Lock()
+	regionRequests = make(map[string]int64)
+	regionMutex.Unlock()
+}
+
+func handleGetRegionLatency(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	config := make(map[string]latencySpec, len(regionLatency))
+	for region, spec := range regionLatency {
+		config[region] = spec
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetRegionLatency replaces the per-region latencies, e.g.
+// {"us-east":20,"ap-south":{"distribution":"normal","mean":300,"stddev":40}}.
+// Values take the same forms as /admin/latency. Regions left out fall back to
+// the endpoint defaults; {} removes them all.
+func handleSetRegionLatency(w http.ResponseWriter, r *http.Request) {
+	var req map[string]latencySpec
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+
+	config := make(map[string]latencySpec, len(req))
+	for region, spec := range req {
+		name := strings.ToLower(region)
+		if !regionNamePattern.MatchString(name) {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request",
+				"Invalid region '"+region+"' (lowercase letters, digits and dashes)")
+			return
+		}
+		if err := spec.validate(); err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid latency for '"+region+"': "+err.Error())
+			return
+		}
+		config[name] = spec
+	}
+
+	cacheMutex.Lock()
+	regionLatency = config
+	cacheMutex.Unlock()
+
+	logger.Info("Region latency updated", "endpoint", "admin", "latency", config)
+
+	handleGetRegionLatency(w, r)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayResult is the live outcome of one recorded request.
+type replayResult struct {
+	rec    recordedRequest
+	status int // 0 when no response came back
+	err    error
+}
+
+// runReplay implements "mock-server replay": it re-issues the requests of a
+// RECORD_FILE against a target and reports every request whose live status
+// differs from the recorded one. It returns the process exit code: 0 when
+// all statuses match, 1 on mismatches, 2 on usage or file errors.
+//
+//	mock-server replay -file requests.jsonl -target http://localhost:8090 [-fast] [-admin-key KEY]
+//
+// By default requests go out at their original relative timing, overlapping
+// as they did when recorded; -fast sends them one after another in recorded
+// order with no pauses.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	file := flags.String("file", "", "JSONL file written via RECORD_FILE (required)")
+	target := flags.String("target", "http://localhost:8090", "base URL to replay against")
+	fast := flags.Bool("fast", false, "send requests back to back instead of at their recorded timing")
+	adminKey := flags.String("admin-key", "", "key to send where the recording has a redacted admin credential")
+	timeout := flags.Duration("timeout", 30*time.Second, "per-request timeout")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "replay: -file is required")
+		flags.Usage()
+		return 2
+	}
+
+	records, err := readRecording(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 2
+	}
+	if len(records) == 0 {
+		fmt.Println("Nothing to replay")
+		return 0
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		// Report redirects as recorded rather than following them
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	base := strings.TrimSuffix(*target, "/")
+	mode := "original timing"
+	if *fast {
+		mode = "fast"
+	}
+	fmt.Printf("Replaying %d request(s) from %s against %s (%s)\n", len(records), *file, base, mode)
+
+	results := make([]replayResult, len(records))
+	if *fast {
+		for i, rec := range records {
+			results[i] = replayOne(client, base, *adminKey, rec)
+		}
+	} else {
+		start := time.Now()
+		first := records[0].Time
+		var wg sync.WaitGroup
+		for i, rec := range records {
+			time.Sleep(time.Until(start.Add(rec.Time.Sub(first))))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = replayOne(client, base, *adminKey, rec)
+			}()
+		}
+		wg.Wait()
+	}
+
+	mismatches := 0
+	for i, result := range results {
+		if result.status == result.rec.Status {
+			continue
+		}
+		mismatches++
+		live := fmt.Sprint(result.status)
+		if result.err != nil {
+			live = "no response (" + result.err.Error() + ")"
+		}
+		fmt.Printf("MISMATCH #%d %s %s: recorded %d, live %s\n", i+1, result.rec.Method, result.rec.Path, result.rec.Status, live)
+	}
+	fmt.Printf("Replayed %d request(s): %d matched, %d mismatched\n", len(results), len(results)-mismatches, mismatches)
+	if mismatches > 0 {
+		return 1
+	}
+	return 0
+}
+
+// readRecording parses a RECORD_FILE and orders it by time, as the writer may
+// have appended concurrent requests slightly out of order.
+func readRecording(path string) ([]recordedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []recordedRequest
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 4*maxRecordedBody) // lines carry bodies up to maxRecordedBody, escaped
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec recordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// Stable, so requests recorded at the same instant keep file order
+	slices.SortStableFunc(records, func(a, b recordedRequest) int { return a.Time.Compare(b.Time) })
+	return records, nil
+}
+
+// replayOne sends one recorded request and returns its live status.
+func replayOne(client *http.Client, base, adminKey string, rec recordedRequest) replayResult {
+	body := []byte(rec.Body)
+	if rec.BodyBase64 != nil {
+		body = rec.BodyBase64
+	}
+	req, err := http.NewRequest(rec.Method, base+rec.Path, bytes.NewReader(body))
+	if err != nil {
+		return replayResult{rec: rec, err: err}
+	}
+	for name, values := range rec.Headers {
+		// The client computes its own framing
+		if name == "Content-Length" || name == "Connection" {
+			continue
+		}
+		req.Header[name] = values
+	}
+	for _, name := range redactedHeaders {
+		if req.Header.Get(name) == "" {
+			continue
+		}
+		if adminKey == "" {
+			req.Header.Del(name)
+		} else if name == "Authorization" {
+			req.Header.Set(name, "Bearer "+adminKey)
+		} else {
+			req.Header.Set(name, adminKey)
+		}
+	}
+
+	// No response counts as status 0, which matches a recorded reset or hang
+	resp, err := client.Do(req)
+	if err != nil {
+		return replayResult{rec: rec, err: err}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return replayResult{rec: rec, status: resp.StatusCode}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// Longest client-supplied request ID we'll adopt; anything longer (or with
+// non-printable characters) is replaced so it can't bloat or garble the logs.
+const maxRequestIdLength = 128
+
+type requestIdKey struct{}
+
+// withRequestId tags every request with a correlation ID: the client's
+// X-Request-Id when usable, otherwise a fresh UUID. The ID is echoed in the
+// response header and attached to the request's log lines by requestLogger.
+func withRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !validRequestId(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(requestIdHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id)))
+	})
+}
+
+// requestId returns the correlation ID assigned by withRequestId, or "".
+func requestId(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdKey{}).(string)
+	return id
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestId returns a random (version 4) UUID.
+func newRequestId() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
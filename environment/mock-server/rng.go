@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// Every simulated decision (error rolls, item failures, latency, lifecycle
+// outcomes) draws from here. By default that's the auto-seeded global source;
+// with RNG_SEED set it's a single seeded generator, so the same seed and the
+// same request order reproduce the same decisions run after run.
+var (
+	// Only assigned at startup, so checking it for nil needs no lock; the
+	// mutex serializes draws since *rand.Rand isn't safe for concurrent use
+	rngMutex sync.Mutex
+	rng      *rand.Rand // nil means the global source
+
+	// The RNG_SEED in effect; set once at startup, nil when unseeded
+	rngSeed *uint64
+)
+
+// seedRNG switches to a generator seeded with seed. Only called at startup.
+func seedRNG(seed uint64) {
+	rng = rand.New(rand.NewPCG(seed, seed))
+	rngSeed = &seed
+}
+
+// randFloat64 returns a pseudo-random number in [0.0,1.0).
+func randFloat64() float64 {
+	if rng == nil {
+		return rand.Float64()
+	}
+	rngMutex.Lock()
+	defer rngMutex.Unlock()
+	return rng.Float64()
+}
+
+// randNormFloat64 returns a standard normally distributed number.
+func randNormFloat64() float64 {
+	if rng == nil {
+		return rand.NormFloat64()
+	}
+	rngMutex.Lock()
+	defer rngMutex.Unlock()
+	return rng.NormFloat64()
+}
+
+// Set via DETERMINISTIC=true at startup: random failure rolls never fire, so
+// the only errors are the ones explicitly requested (X-Force-Error, X-Hang-Ms,
+// X-Reset, listed IDB failures).
+var deterministicMode bool
+
+// rollFailure reports whether a failure with the given probability happens
+// on this request. Always false in deterministic mode.
+func rollFailure(rate float64) bool {
+	return !deterministicMode && rate > 0 && randFloat64() < rate
+}
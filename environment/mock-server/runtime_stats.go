@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ReadMemStats briefly stops the world, so a reading is reused for this long
+// however often /admin/runtime is polled.
+const runtimeStatsMaxAge = time.Second
+
+// runtimeStats is a cheap view of the process for spotting leaks over a long
+// run, e.g. goroutines left behind by hung requests or status streams.
+type runtimeStats struct {
+	Goroutines       int        `json:"goroutines"`
+	InFlightRequests int64      `json:"inFlightRequests"`
+	HeapAllocBytes   uint64     `json:"heapAllocBytes"`
+	HeapObjects      uint64     `json:"heapObjects"`
+	TotalAllocBytes  uint64     `json:"totalAllocBytes"`
+	SysBytes         uint64     `json:"sysBytes"`
+	NumGC            uint32     `json:"numGC"`
+	GCPauseTotalMs   float64    `json:"gcPauseTotalMs"`
+	LastGC           *time.Time `json:"lastGC"` // null before the first GC
+	ReadAt           time.Time  `json:"readAt"`
+}
+
+var (
+	runtimeStatsMutex sync.Mutex
+	lastRuntimeStats  runtimeStats
+)
+
+// readRuntimeStats returns the memory figures of the last reading if it is
+// recent enough and takes a new one otherwise. Goroutine and in-flight
+// counts are always current.
+func readRuntimeStats() runtimeStats {
+	runtimeStatsMutex.Lock()
+	defer runtimeStatsMutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(lastRuntimeStats.ReadAt) >= runtimeStatsMaxAge {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		lastRuntimeStats = runtimeStats{
+			HeapAllocBytes:  mem.HeapAlloc,
+			HeapObjects:     mem.HeapObjects,
+			TotalAllocBytes: mem.TotalAlloc,
+			SysBytes:        mem.Sys,
+			NumGC:           mem.NumGC,
+			GCPauseTotalMs:  float64(mem.PauseTotalNs) / float64(time.Millisecond),
+			ReadAt:          now.UTC(),
+		}
+		if mem.LastGC > 0 {
+			lastGC := time.Unix(0, int64(mem.LastGC)).UTC()
+			lastRuntimeStats.LastGC = &lastGC
+		}
+	}
+	stats := lastRuntimeStats
+	stats.Goroutines = runtime.NumGoroutine()
+	stats.InFlightRequests = inFlightRequests.Load()
+	return stats
+}
+
+func handleAdminRuntime(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readRuntimeStats())
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Server timeouts, so a slow or stalled client can't hold a connection
+// forever (overridable via SERVER_READ_TIMEOUT, SERVER_WRITE_TIMEOUT and
+// SERVER_IDLE_TIMEOUT; 0 disables one). Responses meant to outlast them, the
+// simulated hangs and the status stream, lift them per request.
+var (
+	serverReadTimeout  = 30 * time.Second  // whole request, headers and body
+	serverWriteTimeout = 60 * time.Second  // from the end of the request headers to the end of the response
+	serverIdleTimeout  = 120 * time.Second // keep-alive connections between requests
+)
+
+// exemptFromTimeouts clears this request's read and write deadlines. The read
+// deadline matters too: once it passes, the server treats the connection as
+// broken and cancels the request context.
+func exemptFromTimeouts(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Bumped whenever stateSnapshot changes incompatibly; restore rejects blobs
+// of any other version.
+const stateSnapshotVersion = 1
+
+// lruEntry is one cache entry in a snapshot. Entries are kept most recent
+// first so a restore reproduces the eviction order.
+type lruEntry[V any] struct {
+	Key   string `json:"key"`
+	Value V      `json:"value"`
+}
+
+// stateSnapshot is the blob behind /admin/snapshot and /admin/restore: the
+// caches, the payment stores and the runtime configuration. Clients should
+// treat it as opaque. Startup-only settings, the webhook target and request
+// statistics are not included.
+type stateSnapshot struct {
+	Version int       `json:"version"`
+	TakenAt time.Time `json:"takenAt"`
+
+	GatewayCache         []lruEntry[gatewayEntry]       `json:"gatewayCache"`
+	CustomerGatewayCache []lruEntry[string]             `json:"customerGatewayCache"`
+	IdbSuccessSet        []string                       `json:"idbSuccessSet"`
+	IdbIdempotencyKeys   []lruEntry[idempotentResponse] `json:"idbIdempotencyKeys"`
+	IdbNotifiedAt        []lruEntry[time.Time]          `json:"idbNotifiedAt"`
+	PgiSuccessSet        []string                       `json:"pgiSuccessSet"`
+	Tenants              map[string]tenantSnapshot      `json:"tenants"`
+
+	PaymentStates          map[string]paymentState   `json:"paymentStates"`
+	PaymentDetailOverrides map[string]paymentDetails `json:"paymentDetailOverrides"`
+	PaymentRefunds         map[string]int64          `json:"paymentRefunds"`
+	RefundSeq              int64                     `json:"refundSeq"`
+	PgiOutcomes            map[string]pgiOutcome     `json:"pgiOutcomes"`
+	MissingPrefix          string                    `json:"missingPrefix"`
+	MissingIds             []string                  `json:"missingIds"`
+	Disputes               map[string]dispute        `json:"disputes"`
+	PaymentPollsToTerminal map[string]int            `json:"paymentPollsToTerminal"`
+
+	Config snapshotConfig `json:"config"`
+}
+
+// tenantSnapshot holds a named tenant's caches.
+type tenantSnapshot struct {
+	GatewayCache       []lruEntry[gatewayEntry]       `json:"gatewayCache"`
+	IdbSuccessSet      []string                       `json:"idbSuccessSet"`
+	IdbIdempotencyKeys []lruEntry[idempotentResponse] `json:"idbIdempotencyKeys"`
+	IdbNotifiedAt      []lruEntry[time.Time]          `json:"idbNotifiedAt"`
+	PgiSuccessSet      []string                       `json:"pgiSuccessSet"`
+}
+
+type snapshotConfig struct {
+	EsErrorRate          float64                      `json:"esErrorRate"`
+	IdbErrorRate         float64                      `json:"idbErrorRate"`
+	PgiErrorRate         float64                      `json:"pgiErrorRate"`
+	PgiGatewayErrorRates map[string]float64           `json:"pgiGatewayErrorRates"`
+	Gateways             []string                     `json:"gateways"`
+	GatewayWeights       map[string]int               `json:"gatewayWeights"`
+	RoundRobinNext       int                          `json:"roundRobinNext"`
+	GatewayCacheTTL      time.Duration                `json:"gatewayCacheTtl"`
+	Latency              map[string]latencySpec       `json:"latency"`
+	RegionLatency        map[string]latencySpec       `json:"regionLatency"`
+	HangRate             float64                      `json:"hangRate"`
+	ResetRate            float64                      `json:"resetRate"`
+	Unavailable          map[string]unavailableConfig `json:"unavailable"`
+	FaultBursts          []faultBurst                 `json:"faultBursts"`
+	IdbMaxBodyBytes      int64                        `json:"idbMaxBodyBytes"`
+	IdbMaxBatchSize      int                          `json:"idbMaxBatchSize"`
+	IdbDedupWindow       time.Duration                `json:"idbDedupWindow"`
+	EsConsistencyDelay   time.Duration                `json:"esConsistencyDelay"`
+	IdbItemFailureRate   float64                      `json:"idbItemFailureRate"`
+	IdbFailingIds        []string                     `json:"idbFailingIds"`
+	StatusDwell          time.Duration                `json:"statusDwell"`
+	StatusFailureRate    float64                      `json:"statusFailureRate"`
+	ManualCapture        bool                         `json:"manualCapture"`
+	PollsToTerminal      int                          `json:"pollsToTerminal"`
+	RateLimitRPS         float64                      `json:"rateLimitRps"`
+	RateLimitBurst       int                          `json:"rateLimitBurst"`
+	Fees                 map[string][]feeRule         `json:"fees"`
+	FeeRounding          string                       `json:"feeRounding"`
+}
+
+func dumpLRU[V any](c *lruCache[V]) []lruEntry[V] {
+	entries := make([]lruEntry[V], 0, c.Len())
+	c.Range(func(key string, value V) {
+		entries = append(entries, lruEntry[V]{Key: key, Value: value})
+	})
+	return entries
+}
+
+// restoreLRU replaces the contents of c, inserting in reverse so the most
+// recent entry ends up at the front again.
+func restoreLRU[V any](c *lruCache[V], entries []lruEntry[V]) {
+	c.Clear()
+	for i := len(entries) - 1; i >= 0; i-- {
+		c.Put(entries[i].Key, entries[i].Value)
+	}
+}
+
+func restoreSet(c *lruCache[struct{}], keys []string) {
+	c.Clear()
+	for i := len(keys) - 1; i >= 0; i-- {
+		c.Put(keys[i], struct{}{})
+	}
+}
+
+// takeStateSnapshot copies the whole simulator state under every lock, so
+// the result is one consistent point in time that later changes don't touch.
+func takeStateSnapshot() stateSnapshot {
+	cacheMutex.RLock()
+	defer cacheMutex.RUnlock()
+	rlockCaches()
+	defer runlockCaches()
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	snapshot := stateSnapshot{
+		Version:                stateSnapshotVersion,
+		TakenAt:                time.Now().UTC(),
+		GatewayCache:           dumpLRU(gatewayCache),
+		CustomerGatewayCache:   dumpLRU(customerGatewayCache),
+		IdbSuccessSet:          idbSuccessSet.Keys(),
+		IdbIdempotencyKeys:     dumpLRU(idbIdempotencyKeys),
+		IdbNotifiedAt:          dumpLRU(idbNotifiedAt),
+		PgiSuccessSet:          pgiSuccessSet.Keys(),
+		PaymentStates:          make(map[string]paymentState, len(paymentStates)),
+		Disputes:               make(map[string]dispute, len(disputes)),
+		PaymentDetailOverrides: maps.Clone(paymentDetailOverrides),
+		PaymentRefunds:         maps.Clone(paymentRefunds),
+		RefundSeq:              refundSeq,
+		PgiOutcomes:            maps.Clone(pgiOutcomes),
+		MissingPrefix:          missingPrefix,
+		MissingIds:             setKeys(missingIds),
+		PaymentPollsToTerminal: maps.Clone(paymentPollsToTerminal),
+		Config: snapshotConfig{
+			EsErrorRate:          esErrorRate,
+			IdbErrorRate:         idbErrorRate,
+			PgiErrorRate:         pgiErrorRate,
+			PgiGatewayErrorRates: maps.Clone(pgiGatewayErrorRates),
+			Gateways:             slices.Clone(gateways),
+			GatewayWeights:       maps.Clone(gatewayWeights),
+			RoundRobinNext:       int(roundRobinNext.Load()),
+			GatewayCacheTTL:      gatewayCacheTTL,
+			Latency:              maps.Clone(latencyConfig),
+			RegionLatency:        maps.Clone(regionLatency),
+			HangRate:             hangRate,
+			ResetRate:            resetRate,
+			Unavailable:          maps.Clone(unavailableConfigs),
+			FaultBursts:          slices.Clone(faultBursts),
+			IdbMaxBodyBytes:      idbMaxBodyBytes,
+			IdbMaxBatchSize:      idbMaxBatchSize,
+			IdbDedupWindow:       idbDedupWindow,
+			EsConsistencyDelay:   esConsistencyDelay,
+			IdbItemFailureRate:   idbItemFailureRate,
+			IdbFailingIds:        setKeys(idbFailingIds),
+			StatusDwell:          statusDwell,
+			StatusFailureRate:    statusFailureRate,
+			ManualCapture:        manualCapture,
+			PollsToTerminal:      pollsToTerminal,
+			RateLimitRPS:         rateLimitRPS,
+			RateLimitBurst:       rateLimitBurst,
+			Fees:                 maps.Clone(feeSchedule),
+			FeeRounding:          feeRounding,
+		},
+	}
+	for paymentId, state := range paymentStates {
+		snapshot.PaymentStates[paymentId] = *state
+	}
+	for paymentId, d := range disputes {
+		snapshot.Disputes[paymentId] = *d
+	}
+	named := namedTenants()
+	snapshot.Tenants = make(map[string]tenantSnapshot, len(named))
+	for tenant, caches := range named {
+		snapshot.Tenants[tenant] = tenantSnapshot{
+			GatewayCache:       dumpLRU(caches.gateway),
+			IdbSuccessSet:      caches.idb.Keys(),
+			IdbIdempotencyKeys: dumpLRU(caches.idempotencyKeys),
+			IdbNotifiedAt:      dumpLRU(caches.notifiedAt),
+			PgiSuccessSet:      caches.pgi.Keys(),
+		}
+	}
+	return snapshot
+}
+
+// restoreStateSnapshot replaces the whole simulator state with snapshot.
+// Every lock is held throughout, so no request sees a mix of old and new
+// state. Rate limit buckets start over.
+func restoreStateSnapshot(snapshot stateSnapshot) {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	lockCaches()
+	defer unlockCaches()
+	rateLimitMutex.Lock()
+	defer rateLimitMutex.Unlock()
+
+	restoreLRU(gatewayCache, snapshot.GatewayCache)
+	restoreLRU(customerGatewayCache, snapshot.CustomerGatewayCache)
+	restoreSet(idbSuccessSet, snapshot.IdbSuccessSet)
+	restoreLRU(idbIdempotencyKeys, snapshot.IdbIdempotencyKeys)
+	restoreLRU(idbNotifiedAt, snapshot.IdbNotifiedAt)
+	restoreSet(pgiSuccessSet, snapshot.PgiSuccessSet)
+	restored := make(map[string]*tenantCaches, len(snapshot.Tenants))
+	for tenant, dump := range snapshot.Tenants {
+		caches := newTenantCaches()
+		restoreLRU(caches.gateway, dump.GatewayCache)
+		restoreSet(caches.idb, dump.IdbSuccessSet)
+		restoreLRU(caches.idempotencyKeys, dump.IdbIdempotencyKeys)
+		restoreLRU(caches.notifiedAt, dump.IdbNotifiedAt)
+		restoreSet(caches.pgi, dump.PgiSuccessSet)
+		restored[tenant] = caches
+	}
+	tenantMutex.Lock()
+	tenants = restored
+	tenantMutex.Unlock()
+
+	paymentStates = make(map[string]*paymentState, len(snapshot.PaymentStates))
+	for paymentId, state := range snapshot.PaymentStates {
+		paymentStates[paymentId] = &state
+	}
+	disputes = make(map[string]*dispute, len(snapshot.Disputes))
+	for paymentId, d := range snapshot.Disputes {
+		disputes[paymentId] = &d
+	}
+	paymentDetailOverrides = orEmpty(snapshot.PaymentDetailOverrides)
+	paymentRefunds = orEmpty(snapshot.PaymentRefunds)
+	refundSeq = snapshot.RefundSeq
+	pgiOutcomes = orEmpty(snapshot.PgiOutcomes)
+	missingPrefix = snapshot.MissingPrefix
+	missingIds = keySet(snapshot.MissingIds)
+	paymentPollsToTerminal = orEmpty(snapshot.PaymentPollsToTerminal)
+
+	config := snapshot.Config
+	esErrorRate = config.EsErrorRate
+	idbErrorRate = config.IdbErrorRate
+	pgiErrorRate = config.PgiErrorRate
+	pgiGatewayErrorRates = orEmpty(config.PgiGatewayErrorRates)
+	gateways = config.Gateways
+	gatewayWeights = orEmpty(config.GatewayWeights)
+	roundRobinNext.Store(uint64(config.RoundRobinNext))
+	gatewayCacheTTL = config.GatewayCacheTTL
+	latencyConfig = orEmpty(config.Latency)
+	regionLatency = orEmpty(config.RegionLatency)
+	hangRate = config.HangRate
+	resetRate = config.ResetRate
+	unavailableConfigs = orEmpty(config.Unavailable)
+	faultBursts = config.FaultBursts
+	idbMaxBodyBytes = config.IdbMaxBodyBytes
+	idbMaxBatchSize = config.IdbMaxBatchSize
+	idbDedupWindow = config.IdbDedupWindow
+	esConsistencyDelay = config.EsConsistencyDelay
+	idbItemFailureRate = config.IdbItemFailureRate
+	idbFailingIds = keySet(config.IdbFailingIds)
+	statusDwell = config.StatusDwell
+	statusFailureRate = config.StatusFailureRate
+	manualCapture = config.ManualCapture
+	pollsToTerminal = cmp.Or(config.PollsToTerminal, defaultPollsToTerminal) // 0 in older snapshots
+	rateLimitRPS = config.RateLimitRPS
+	rateLimitBurst = config.RateLimitBurst
+	feeSchedule = orEmpty(config.Fees)
+	feeRounding = config.FeeRounding
+	rateLimitBuckets = make(map[string]*tokenBucket)
+}
+
+// validate rejects blobs that were not produced by /admin/snapshot of this
+// server version, or that would leave the simulator unusable.
+func (s stateSnapshot) validate() error {
+	if s.Version != stateSnapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d (expected %d)", s.Version, stateSnapshotVersion)
+	}
+	if len(s.Config.Gateways) == 0 {
+		return fmt.Errorf("snapshot has no gateways")
+	}
+	for _, name := range s.Config.Gateways {
+		if !gatewayNamePattern.MatchString(name) {
+			return fmt.Errorf("snapshot has invalid gateway name %q", name)
+		}
+	}
+	for name, weight := range s.Config.GatewayWeights {
+		if weight < 0 || weight > maxGatewayWeight {
+			return fmt.Errorf("snapshot has invalid weight %d for gateway %q", weight, name)
+		}
+	}
+	if len(s.Tenants) > maxTenants {
+		return fmt.Errorf("snapshot has more than %d tenants", maxTenants)
+	}
+	for tenant := range s.Tenants {
+		if !validNamedTenant(tenant) {
+			return fmt.Errorf("snapshot has invalid tenant %q", tenant)
+		}
+	}
+	if !slices.Contains(feeRoundingModes, s.Config.FeeRounding) {
+		return fmt.Errorf("snapshot has invalid fee rounding %q", s.Config.FeeRounding)
+	}
+	if s.Config.PollsToTerminal < 0 {
+		return fmt.Errorf("snapshot has invalid pollsToTerminal %d", s.Config.PollsToTerminal)
+	}
+	if s.Config.IdbMaxBodyBytes < 1 || s.Config.IdbMaxBatchSize < 0 || s.Config.RateLimitBurst < 1 {
+		return fmt.Errorf("snapshot has invalid IDB or rate limits")
+	}
+	return nil
+}
+
+func setKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func keySet(keys []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		set[key] = struct{}{}
+	}
+	return set
+}
+
+// orEmpty keeps restored maps non-nil, as the handlers write into them.
+func orEmpty[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return make(map[K]V)
+	}
+	return m
+}
+
+// handleAdminSnapshot returns the current state as a blob for /admin/restore,
+// so a test suite can capture a baseline once and return to it between
+// cases without restarting the server.
+func handleAdminSnapshot(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(takeStateSnapshot())
+}
+
+// handleAdminRestore atomically replaces the current state with a blob from
+// /admin/snapshot. An invalid blob is rejected with 400 and changes nothing.
+func handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	var snapshot stateSnapshot
+	if err := json.NewDecoder(r.Body).Decode(&snapshot); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid snapshot")
+		return
+	}
+	if err := snapshot.validate(); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+		return
+	}
+
+	restoreStateSnapshot(snapshot)
+	logger.Info("State restored from snapshot", "takenAt", snapshot.TakenAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"restored": true,
+		"takenAt":  snapshot.TakenAt,
+	})
+}
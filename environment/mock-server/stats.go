@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+)
+
+// endpointStats counts traffic for one business endpoint. Counters are
+// atomic so handlers never contend on cacheMutex to update them.
+type endpointStats struct {
+	requests       atomic.Int64
+	injectedErrors atomic.Int64
+	forcedErrors   atomic.Int64
+	cacheHits      atomic.Int64
+}
+
+type endpointStatsSnapshot struct {
+	Requests       int64 `json:"requests"`
+	InjectedErrors int64 `json:"injectedErrors"`
+	ForcedErrors   int64 `json:"forcedErrors"`
+	CacheHits      int64 `json:"cacheHits"`
+}
+
+// requestStats is populated by instrument() while routes are registered and
+// only read afterwards, so the map itself needs no lock.
+var requestStats = make(map[string]*endpointStats)
+
+// registerEndpointStats returns the counters for endpoint, creating them on
+// first use. Only call during route registration.
+func registerEndpointStats(endpoint string) *endpointStats {
+	if s, ok := requestStats[endpoint]; ok {
+		return s
+	}
+	s := &endpointStats{}
+	requestStats[endpoint] = s
+	return s
+}
+
+// cacheEndpoints maps a success cache to the endpoint that reads it.
+var cacheEndpoints = map[string]string{
+	"gateway": "es",
+	"idb":     "idb",
+	"pgi":     "pgi",
+}
+
+// cacheLookupStats counts lookups against one success cache.
+type cacheLookupStats struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheLookupSnapshot struct {
+	Hits     int64   `json:"hits"`
+	Misses   int64   `json:"misses"`
+	HitRatio float64 `json:"hitRatio"` // hits / (hits + misses); 0 before any lookup
+}
+
+// Per-cache lookup counters, keyed like cacheEndpoints. Fixed at startup.
+var cacheLookups = map[string]*cacheLookupStats{
+	"gateway": {},
+	"idb":     {},
+	"pgi":     {},
+}
+
+func snapshotCacheLookups() map[string]cacheLookupSnapshot {
+	result := make(map[string]cacheLookupSnapshot, len(cacheLookups))
+	for cache, s := range cacheLookups {
+		snapshot := cacheLookupSnapshot{Hits: s.hits.Load(), Misses: s.misses.Load()}
+		if total := snapshot.Hits + snapshot.Misses; total > 0 {
+			snapshot.HitRatio = float64(snapshot.Hits) / float64(total)
+		}
+		result[cache] = snapshot
+	}
+	return result
+}
+
+func snapshotRequestStats() map[string]endpointStatsSnapshot {
+	result := make(map[string]endpointStatsSnapshot, len(requestStats))
+	for endpoint, s := range requestStats {
+		result[endpoint] = endpointStatsSnapshot{
+			Requests:       s.requests.Load(),
+			InjectedErrors: s.injectedErrors.Load(),
+			ForcedErrors:   s.forcedErrors.Load(),
+			CacheHits:      s.cacheHits.Load(),
+		}
+	}
+	return result
+}
+
+func handleAdminStatsReset(w http.ResponseWriter, _ *http.Request) {
+	for _, s := range requestStats {
+		s.requests.Store(0)
+		s.injectedErrors.Store(0)
+		s.forcedErrors.Store(0)
+		s.cacheHits.Store(0)
+	}
+	for _, s := range cacheLookups {
+		s.hits.Store(0)
+		s.misses.Store(0)
+	}
+	resetRegionRequests()
+
+	logger.Info("Request stats reset", "endpoint", "admin")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "stats reset"})
+}
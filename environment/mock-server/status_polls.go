@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// With no dwell time, a payment settles on its pollsToTerminal-th
+// check-status call: the first poll creates it in pending and the ones in
+// between show processing. The default of 3 gives pending, processing, then
+// succeeded or failed (authorized with manual capture). A threshold of 1
+// settles a payment on its first poll.
+const defaultPollsToTerminal = 3
+
+var (
+	// Guarded by cacheMutex, adjustable via /admin/payment-status
+	pollsToTerminal = defaultPollsToTerminal
+
+	// Per-payment thresholds overriding pollsToTerminal (guarded by
+	// cacheMutex, adjustable via /admin/payment-status/polls)
+	paymentPollsToTerminal = make(map[string]int)
+)
+
+// pollThreshold returns how many polls paymentId takes to settle. Caller
+// must hold cacheMutex.
+func pollThreshold(paymentId string) int {
+	if polls, ok := paymentPollsToTerminal[paymentId]; ok {
+		return polls
+	}
+	return pollsToTerminal
+}
+
+// advanceByPolls moves a payment that has just been polled: it settles once
+// its poll count reaches threshold and is processing before that.
+func (s *paymentState) advanceByPolls(at time.Time, threshold int) {
+	switch {
+	case s.settled():
+	case s.Polls >= threshold:
+		s.Status = statusProcessing
+		s.advance(at)
+	case s.Status == statusPending:
+		s.advance(at)
+	}
+}
+
+func handleGetPaymentPolls(w http.ResponseWriter, _ *http.Request) {
+	cacheMutex.RLock()
+	thresholds := make(map[string]int, len(paymentPollsToTerminal))
+	for paymentId, polls := range paymentPollsToTerminal {
+		thresholds[paymentId] = polls
+	}
+	cacheMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(thresholds)
+}
+
+// handleSetPaymentPolls sets how many polls one payment takes to settle, e.g.
+// {"paymentId":"pay_1","pollsToTerminal":10}. Polls already made count, so a
+// payment past the new threshold settles on its next poll.
+func handleSetPaymentPolls(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PaymentId       string `json:"paymentId"`
+		PollsToTerminal int    `json:"pollsToTerminal"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.PaymentId == "" {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "paymentId is required")
+		return
+	}
+	if req.PollsToTerminal < 1 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "pollsToTerminal must be at least 1")
+		return
+	}
+
+	cacheMutex.Lock()
+	paymentPollsToTerminal[req.PaymentId] = req.PollsToTerminal
+	cacheMutex.Unlock()
+
+	logger.Info("Payment poll threshold set", "endpoint", "admin", "paymentId", req.PaymentId, "pollsToTerminal", req.PollsToTerminal)
+
+	handleGetPaymentPolls(w, r)
+}
+
+// handleDeletePaymentPolls returns a payment to the global pollsToTerminal.
+func handleDeletePaymentPolls(w http.ResponseWriter, r *http.Request) {
+	paymentId := r.PathValue("paymentId")
+
+	cacheMutex.Lock()
+	_, exists := paymentPollsToTerminal[paymentId]
+	delete(paymentPollsToTerminal, paymentId)
+	cacheMutex.Unlock()
+
+	if !exists {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "No poll threshold configured for payment '"+paymentId+"'")
+		return
+	}
+
+	logger.Info("Payment poll threshold removed", "endpoint", "admin", "paymentId", paymentId)
+
+	handleGetPaymentPolls(w, r)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Requests naming a tenant in X-Tenant-Id get their own gateway, IDB and PGI
+// success caches, IDB idempotency keys and dedup window, so test suites
+// sharing one instance don't see each other's cached successes. Requests
+// without the header (or with "default") use the default tenant's caches.
+// Payment state and the customer gateway cache stay shared.
+const (
+	tenantHeader  = "X-Tenant-Id"
+	defaultTenant = "default"
+
+	maxTenants = 256
+)
+
+var tenantIdPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// tenantCaches is one tenant's set of success caches. Each cache is guarded
+// by the lock of its kind (gatewayCacheMutex, idbCacheMutex, pgiCacheMutex),
+// shared by all tenants.
+type tenantCaches struct {
+	gateway *lruCache[gatewayEntry]
+	idb     *lruCache[struct{}]
+	pgi     *lruCache[struct{}]
+
+	// IDB responses by Idempotency-Key and last notify by idbCacheKey, both
+	// sharing the idb limit
+	idempotencyKeys *lruCache[idempotentResponse]
+	notifiedAt      *lruCache[time.Time]
+}
+
+var (
+	defaultCaches = &tenantCaches{
+		gateway:         gatewayCache,
+		idb:             idbSuccessSet,
+		pgi:             pgiSuccessSet,
+		idempotencyKeys: idbIdempotencyKeys,
+		notifiedAt:      idbNotifiedAt,
+	}
+
+	// Caches per named tenant, created on first use. tenantMutex comes after
+	// pgiCacheMutex in the lock order
+	tenantMutex sync.Mutex
+	tenants     = make(map[string]*tenantCaches)
+)
+
+var errTooManyTenants = errors.New("too many tenants (max " + strconv.Itoa(maxTenants) + "); clear one via /admin/cache/clear?tenant=&confirm=true")
+
+// lookupTenant returns the tenant's caches, creating them when create is set.
+// It returns nil for an unknown tenant when create is not set.
+func lookupTenant(tenant string, create bool) (*tenantCaches, error) {
+	if tenant == "" || tenant == defaultTenant {
+		return defaultCaches, nil
+	}
+	if !tenantIdPattern.MatchString(tenant) {
+		return nil, errors.New("invalid tenant '" + tenant + "' (letters, digits, '.', '_' and '-', up to 64)")
+	}
+	tenantMutex.Lock()
+	caches, ok := tenants[tenant]
+	tenantMutex.Unlock()
+	if ok || !create {
+		return caches, nil
+	}
+
+	// New caches take the default tenant's size limits. Hold the cache locks
+	// until they are registered so a concurrent limit change can't miss them
+	rlockCaches()
+	defer runlockCaches()
+	return createTenant(tenant)
+}
+
+// createTenant returns the named tenant's caches, creating them if needed.
+// The name must already be valid. Caller must hold the cache locks.
+func createTenant(tenant string) (*tenantCaches, error) {
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+	if caches, ok := tenants[tenant]; ok {
+		return caches, nil
+	}
+	if len(tenants) >= maxTenants {
+		return nil, errTooManyTenants
+	}
+	caches := newTenantCaches()
+	tenants[tenant] = caches
+	logger.Info("Tenant created", "tenant", tenant)
+	return caches, nil
+}
+
+// validNamedTenant reports whether tenant can name a tenant other than the
+// default one.
+func validNamedTenant(tenant string) bool {
+	return tenant != defaultTenant && tenantIdPattern.MatchString(tenant)
+}
+
+// newTenantCaches returns empty caches with the default tenant's size
+// limits. Caller must hold the cache locks.
+func newTenantCaches() *tenantCaches {
+	return &tenantCaches{
+		gateway:         newLRUCache[gatewayEntry](gatewayCache.MaxEntries()),
+		idb:             newLRUCache[struct{}](idbSuccessSet.MaxEntries()),
+		pgi:             newLRUCache[struct{}](pgiSuccessSet.MaxEntries()),
+		idempotencyKeys: newLRUCache[idempotentResponse](idbSuccessSet.MaxEntries()),
+		notifiedAt:      newLRUCache[time.Time](idbSuccessSet.MaxEntries()),
+	}
+}
+
+// namedTenants returns the named tenants' caches. Caller must hold the cache
+// locks for whichever caches it touches.
+func namedTenants() map[string]*tenantCaches {
+	tenantMutex.Lock()
+	defer tenantMutex.Unlock()
+	return maps.Clone(tenants)
+}
+
+type tenantKey struct{}
+
+// requestTenant is the tenant a request was resolved to by withTenant.
+type requestTenant struct {
+	id     string
+	caches *tenantCaches
+}
+
+// withTenant resolves X-Tenant-Id to the tenant's caches for the handlers to
+// pick up with requestCaches; requestLogger tags the log lines with it. An
+// invalid tenant, or a new one past maxTenants, gets a 400.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(tenantHeader)
+		if tenant == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		caches, err := lookupTenant(tenant, true)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+			return
+		}
+		scope := &requestTenant{id: tenant, caches: caches}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, scope)))
+	})
+}
+
+// requestCaches returns the caches of the request's tenant.
+func requestCaches(r *http.Request) *tenantCaches {
+	if scope, ok := r.Context().Value(tenantKey{}).(*requestTenant); ok {
+		return scope.caches
+	}
+	return defaultCaches
+}
+
+// tenantId returns the request's X-Tenant-Id as resolved by withTenant, or "".
+func tenantId(r *http.Request) string {
+	if scope, ok := r.Context().Value(tenantKey{}).(*requestTenant); ok {
+		return scope.id
+	}
+	return ""
+}
+
+// adminTenant resolves the ?tenant= of an admin request, writing a 400 for an
+// invalid one and a 404 for an unknown one unless create is set.
+func adminTenant(w http.ResponseWriter, r *http.Request, create bool) (*tenantCaches, bool) {
+	tenant := r.URL.Query().Get("tenant")
+	caches, err := lookupTenant(tenant, create)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", err.Error())
+		return nil, false
+	}
+	if caches == nil {
+		writeProblem(w, http.StatusNotFound, codeNotFound, "Not Found", "Unknown tenant '"+tenant+"'")
+		return nil, false
+	}
+	return caches, true
+}
+
+// tenantSummary is one tenant's cache sizes for /admin/tenants.
+type tenantSummary struct {
+	Tenant           string `json:"tenant"`
+	GatewayCacheSize int    `json:"gatewayCacheSize"`
+	IdbSuccessCount  int    `json:"idbSuccessCount"`
+	PgiSuccessCount  int    `json:"pgiSuccessCount"`
+}
+
+// handleListTenants lists the default tenant and every named one, in name
+// order, with their cache sizes.
+func handleListTenants(w http.ResponseWriter, _ *http.Request) {
+	rlockCaches()
+	named := namedTenants()
+	summaries := make([]tenantSummary, 0, len(named)+1)
+	for _, tenant := range append([]string{defaultTenant}, slices.Sorted(maps.Keys(named))...) {
+		caches := defaultCaches
+		if tenant != defaultTenant {
+			caches = named[tenant]
+		}
+		summaries = append(summaries, tenantSummary{
+			Tenant:           tenant,
+			GatewayCacheSize: caches.gateway.Len(),
+			IdbSuccessCount:  caches.idb.Len(),
+			PgiSuccessCount:  caches.pgi.Len(),
+		})
+	}
+	runlockCaches()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
@@ -0,0 +1,34 @@
+package main
+
+import "time"
+
+// How the "timestamp" fields of IDB and PGI responses (and webhook callbacks)
+// are written, set via TIMESTAMP_FORMAT at startup:
+//
+//	rfc3339      "2024-01-01T12:00:00Z" (default)
+//	rfc3339nano  "2024-01-01T12:00:00.123456789Z"
+//	unixmillis   1704110400123, as a JSON number
+const (
+	timestampRFC3339     = "rfc3339"
+	timestampRFC3339Nano = "rfc3339nano"
+	timestampUnixMillis  = "unixmillis"
+)
+
+var timestampFormats = []string{timestampRFC3339, timestampRFC3339Nano, timestampUnixMillis}
+
+var timestampFormat = timestampRFC3339
+
+// formatTimestamp renders t, shifted by any configured clock skew, in the
+// configured format. The result is a string, or an int64 for unixmillis,
+// ready to go into a JSON body.
+func formatTimestamp(t time.Time) any {
+	t = t.Add(currentClockSkew())
+	switch timestampFormat {
+	case timestampRFC3339Nano:
+		return t.UTC().Format(time.RFC3339Nano)
+	case timestampUnixMillis:
+		return t.UnixMilli()
+	default:
+		return t.UTC().Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// W3C Trace Context propagation. An incoming traceparent is always passed
+// through: echoed on the response and forwarded on webhook callbacks. With
+// TRACING_ENABLED set the mock also starts a child span per business request
+// (or a new root trace when none came in) and logs it as a "Span" record
+// once the request finishes, so a harness can stitch it into its traces.
+var tracingEnabled bool
+
+type traceContext struct {
+	TraceId      string // 32 hex digits
+	SpanId       string // 16 hex digits; the span the response and callbacks point at
+	ParentSpanId string // caller's span, empty for a root span
+	Flags        string // 2 hex digits
+	State        string // tracestate, passed through untouched
+
+	// Filled in by the handler via requestLogger, guarded by mu
+	mu        sync.Mutex
+	paymentId string
+	gateway   string
+}
+
+func (tc *traceContext) traceparent() string {
+	return "00-" + tc.TraceId + "-" + tc.SpanId + "-" + tc.Flags
+}
+
+// setHeaders writes the trace context headers for a response or outgoing call.
+func (tc *traceContext) setHeaders(h http.Header) {
+	h.Set("traceparent", tc.traceparent())
+	if tc.State != "" {
+		h.Set("tracestate", tc.State)
+	}
+}
+
+// annotate records the payment and gateway the handler is working on, keeping
+// the first non-empty value of each.
+func (tc *traceContext) annotate(paymentId, gateway string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.paymentId == "" {
+		tc.paymentId = paymentId
+	}
+	if tc.gateway == "" {
+		tc.gateway = gateway
+	}
+}
+
+type traceKey struct{}
+
+// startTrace attaches the request's trace context, if any, to its context.
+// Without TRACING_ENABLED only an incoming traceparent is carried through
+// unchanged; with it a child (or root) span is created.
+func startTrace(r *http.Request) (*http.Request, *traceContext) {
+	traceId, parentId, flags, ok := parseTraceparent(r.Header.Get("traceparent"))
+	if !ok && !tracingEnabled {
+		return r, nil
+	}
+
+	tc := &traceContext{TraceId: traceId, SpanId: parentId, Flags: flags, State: r.Header.Get("tracestate")}
+	if tracingEnabled {
+		if !ok {
+			tc.TraceId, tc.Flags, tc.State = randomHex(16), "01", ""
+		} else {
+			tc.ParentSpanId = parentId
+		}
+		tc.SpanId = randomHex(8)
+	}
+	return r.WithContext(context.WithValue(r.Context(), traceKey{}, tc)), tc
+}
+
+// requestTrace returns the trace context attached by startTrace, or nil.
+func requestTrace(r *http.Request) *traceContext {
+	tc, _ := r.Context().Value(traceKey{}).(*traceContext)
+	return tc
+}
+
+// endSpan logs a finished span. Only called with tracing enabled.
+func endSpan(tc *traceContext, endpoint string, start time.Time, status int, errorInjected bool) {
+	tc.mu.Lock()
+	paymentId, gateway := tc.paymentId, tc.gateway
+	tc.mu.Unlock()
+
+	logger.Info("Span",
+		"endpoint", endpoint,
+		"traceId", tc.TraceId,
+		"spanId", tc.SpanId,
+		"parentSpanId", tc.ParentSpanId,
+		"startTime", start.UTC().Format(time.RFC3339Nano),
+		"durationMs", float64(time.Since(start).Microseconds())/1000,
+		"attributes", map[string]any{
+			"endpoint":         endpoint,
+			"paymentId":        paymentId,
+			"gateway":          gateway,
+			"http.status_code": status,
+			"error.injected":   errorInjected,
+		},
+	)
+}
+
+// parseTraceparent validates a version 00 traceparent
+// ("00-<32 hex trace id>-<16 hex parent id>-<2 hex flags>"). All-zero IDs are
+// invalid per the spec.
+func parseTraceparent(value string) (traceId, parentId, flags string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return "", "", "", false
+	}
+	traceId, parentId, flags = parts[1], parts[2], parts[3]
+	if !lowerHex(traceId, 32) || !lowerHex(parentId, 16) || !lowerHex(flags, 2) {
+		return "", "", "", false
+	}
+	if strings.Trim(traceId, "0") == "" || strings.Trim(parentId, "0") == "" {
+		return "", "", "", false
+	}
+	return traceId, parentId, flags, true
+}
+
+func lowerHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !('0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
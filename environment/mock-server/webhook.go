@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Maximum delivery attempts per callback; the gap between attempts doubles
+// starting from the configured delay.
+const webhookMaxAttempts = 3
+
+var (
+	// The callback target is read from a background goroutine per delivery,
+	// so it gets its own lock instead of cacheMutex.
+	webhookMutex  sync.RWMutex
+	webhookURL    string // empty disables callbacks
+	webhookSecret string // empty sends callbacks unsigned
+	webhookDelay  = 500 * time.Millisecond
+	webhookClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// idbCallback is the confirmation the real IDB facade posts back after a notify.
+type idbCallback struct {
+	Event      string   `json:"event"`
+	Gateway    string   `json:"gateway"`
+	PaymentIds []string `json:"paymentIds"`
+	Timestamp  any      `json:"timestamp"`
+}
+
+// parseWebhookURL accepts an absolute http(s) URL.
+func parseWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// signWebhook returns the X-Signature value for a callback body sent at
+// timestamp (unix seconds). The canonical string is the decimal timestamp,
+// a literal ".", then the raw body bytes:
+//
+//	X-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// Receivers should recompute it from X-Signature-Timestamp and the raw body,
+// compare in constant time, and reject stale timestamps to prevent replay.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendIdbCallback delivers the IDB confirmation in the background after the
+// configured delay, carrying the notify's trace context when it has one. It
+// is sent once per processed notify; replays and cached successes send none.
+// It is a no-op when no webhook URL is set or there is nothing to confirm.
+func sendIdbCallback(trace *traceContext, gateway string, paymentIds []string) {
+	webhookMutex.RLock()
+	target, delay, secret := webhookURL, webhookDelay, webhookSecret
+	webhookMutex.RUnlock()
+	if target == "" || len(paymentIds) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(idbCallback{
+		Event:      "idb.notified",
+		Gateway:    gateway,
+		PaymentIds: paymentIds,
+		Timestamp:  formatTimestamp(clockNow()),
+	})
+	if err != nil {
+		logger.Error("Failed to encode webhook payload", "endpoint", "webhook", "err", err)
+		return
+	}
+
+	go deliverWebhook(target, secret, body, delay, gateway, trace)
+}
+
+func deliverWebhook(target, secret string, body []byte, delay time.Duration, gateway string, trace *traceContext) {
+	hookLog := logger.With("endpoint", "webhook", "gateway", gateway, "url", target)
+	backoff := delay
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		time.Sleep(backoff)
+		backoff = max(2*backoff, 100*time.Millisecond)
+
+		err := postWebhook(target, secret, body, trace)
+		if err == nil {
+			hookLog.Debug("Webhook delivered", "attempt", attempt)
+			return
+		}
+		if attempt < webhookMaxAttempts {
+			hookLog.Warn("Webhook delivery failed, retrying", "attempt", attempt, "err", err)
+		} else {
+			hookLog.Error("Webhook delivery failed, giving up", "attempts", attempt, "err", err)
+		}
+	}
+}
+
+// postWebhook sends one delivery attempt. Each attempt is signed with a fresh
+// timestamp so retries are not rejected as replays.
+func postWebhook(target, secret string, body []byte, trace *traceContext) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if trace != nil {
+		trace.setHeaders(req.Header)
+	}
+	if secret != "" {
+		timestamp := time.Now().Unix()
+		req.Header.Set("X-Signature-Timestamp", strconv.FormatInt(timestamp, 10))
+		req.Header.Set("X-Signature", signWebhook(secret, timestamp, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("receiver returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type webhookConfig struct {
+	URL     string `json:"url"`
+	DelayMs int64  `json:"delayMs"`
+	Signed  bool   `json:"signed"` // whether WEBHOOK_SECRET is set; the secret itself is never exposed
+}
+
+func handleGetWebhook(w http.ResponseWriter, _ *http.Request) {
+	webhookMutex.RLock()
+	config := webhookConfig{URL: webhookURL, DelayMs: webhookDelay.Milliseconds(), Signed: webhookSecret != ""}
+	webhookMutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+// handleSetWebhook updates the callback target, e.g.
+// {"url":"http://receiver:9000/idb","delayMs":200}. An empty url disables
+// callbacks; omitted fields are left unchanged.
+func handleSetWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL     *string `json:"url"`
+		DelayMs *int64  `json:"delayMs"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "Invalid request body")
+		return
+	}
+	if req.URL != nil && *req.URL != "" {
+		if err := parseWebhookURL(*req.URL); err != nil {
+			writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "invalid url: "+err.Error())
+			return
+		}
+	}
+	if req.DelayMs != nil && *req.DelayMs < 0 {
+		writeProblem(w, http.StatusBadRequest, codeInvalidRequest, "Bad Request", "delayMs must not be negative")
+		return
+	}
+
+	webhookMutex.Lock()
+	if req.URL != nil {
+		webhookURL = *req.URL
+	}
+	if req.DelayMs != nil {
+		webhookDelay = time.Duration(*req.DelayMs) * time.Millisecond
+	}
+	webhookMutex.Unlock()
+
+	logger.Info("Webhook updated", "endpoint", "admin", "url", req.URL, "delayMs", req.DelayMs)
+
+	handleGetWebhook(w, r)
+}